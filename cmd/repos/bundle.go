@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/repos"
+)
+
+var (
+	BundleFile   string // nolint: gochecknoglobals
+	BundleOut    string // nolint: gochecknoglobals
+	BundleFrom   string // nolint: gochecknoglobals
+	BundleJobs   int    // nolint: gochecknoglobals
+	BundleSwitch bool   // nolint: gochecknoglobals
+)
+
+func init() { // nolint: gochecknoinits
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd, bundleSyncCmd)
+
+	for _, cmd := range []*cobra.Command{bundleCreateCmd, bundleSyncCmd} {
+		addFilterFlags(cmd)
+		cmd.Flags().StringVarP(&BundleFile, "file", "f", "",
+			"configuration file path (default: stdin)")
+		cmd.Flags().IntVarP(&BundleJobs, "jobs", "j", runtime.NumCPU(),
+			"number of repositories to process at the same time")
+	}
+
+	bundleCreateCmd.Flags().StringVarP(&BundleOut, "out", "o", "",
+		"directory, or .tar, .tar.gz or .tgz file, to write (required)")
+	bundleSyncCmd.Flags().StringVar(&BundleFrom, "from", "",
+		"directory, or .tar, .tar.gz or .tgz file, to read (required)")
+	bundleSyncCmd.Flags().BoolVar(&BundleSwitch, "switch-branch", false,
+		"check out the branch= of repositories on another branch")
+}
+
+var bundleCmd = &cobra.Command{ // nolint: gochecknoglobals
+	Use:   "bundle",
+	Short: "transfer repos from a configuration without network access",
+	Long: strings.TrimSpace(`
+bundle transfers the git repositories listed in a configuration to machines
+without network access, such as air-gapped labs, using git bundles.
+
+On a machine with the repositories, write a bundle of each one:
+
+	repos bundle create -f repos.conf -o repos.tar.gz
+
+Then, on the other machine, clone or fetch each repository from its bundle:
+
+	repos bundle sync -f repos.conf --from repos.tar.gz
+
+Both machines use the same configuration.  The bundle of a repository is found
+at a path made of the host and path of its URL, ending with ".bundle".
+`),
+}
+
+var bundleCreateCmd = &cobra.Command{ // nolint: gochecknoglobals
+	Use:   "create",
+	Short: "write a bundle of each repo from a configuration",
+	Long: strings.TrimSpace(`
+create writes a git bundle of every local repository listed in the given
+configuration, with all of its branches and tags.
+
+The bundles are written to the directory given by the -o/--out flag.  When it
+ends with ".tar", ".tar.gz" or ".tgz", a tarball of the bundles is written
+instead.
+
+By default, the configuration is read from standard input (stdin).  You can read
+from a file with the -f/--file flag.
+` + filterHelp),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if BundleOut == "" {
+			return fmt.Errorf("bundle: %w", errs.ErrNoDest)
+		}
+
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return errs.ErrHomeNotFound(err)
+		}
+
+		r, err := parseConfig(BundleFile)
+		if err != nil {
+			return fmt.Errorf("bundle: %w", err)
+		}
+
+		out := repos.ExpandHome(home, BundleOut)
+		archive, compressed := repos.IsArchive(out)
+
+		dir := out
+		if archive {
+			if dir, err = ioutil.TempDir("", "repos-bundle"); err != nil {
+				return fmt.Errorf("bundle: %w", err)
+			}
+
+			defer os.RemoveAll(dir)
+		}
+
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		if err := createBundles(ctx, r, dir); err != nil {
+			return err
+		}
+
+		if !archive {
+			return nil
+		}
+
+		file, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("bundle: failed to open file to write: %w", err)
+		}
+
+		defer file.Close()
+
+		if err := repos.WriteArchive(file, dir, compressed); err != nil {
+			return fmt.Errorf("bundle: %w", err)
+		}
+
+		return file.Close()
+	},
+}
+
+// createBundles writes the bundles to dir, logging or recording the result of
+// every repository and a summary at the end.
+func createBundles(ctx context.Context, r []repos.Repo, dir string) error {
+	var (
+		results                  = make(chan repos.Result, 1)
+		done                     = make(chan struct{})
+		bundled, skipped, failed int
+		start                    = time.Now()
+	)
+
+	go func() {
+		defer close(done)
+
+		for res := range results {
+			switch {
+			case res.Err != nil:
+				failed++
+			case res.Action == repos.ActionSkip:
+				skipped++
+			default:
+				bundled++
+			}
+
+			if structured() {
+				rec := newSyncRecord(res)
+				rec.Type = "bundle"
+				records.Record(rec)
+			} else {
+				logResult("bundle", res)
+			}
+		}
+	}()
+
+	err := repos.CreateBundles(ctx, r, dir, BundleJobs, results)
+
+	<-done
+
+	if structured() {
+		records.Record(bundleSummaryRecord{
+			Type:       "summary",
+			Total:      bundled + skipped + failed,
+			Bundled:    bundled,
+			Skipped:    skipped,
+			Failed:     failed,
+			DurationMS: time.Since(start).Milliseconds(),
+		})
+	} else {
+		log.Printf("bundle: %d repositories in %s: "+
+			"%d bundled, %d skipped, %d failed\n",
+			bundled+skipped+failed, time.Since(start).Round(time.Millisecond),
+			bundled, skipped, failed)
+	}
+
+	if err != nil {
+		return fmt.Errorf("bundle: %w", err)
+	}
+
+	return nil
+}
+
+var bundleSyncCmd = &cobra.Command{ // nolint: gochecknoglobals
+	Use:   "sync",
+	Short: "sync repos from a configuration using bundles",
+	Long: strings.TrimSpace(`
+sync clones or fetches every git repository listed in the given configuration
+from the bundles written by "bundle create", instead of from its remote.
+
+The bundles are read from the directory given by the --from flag.  When it ends
+with ".tar", ".tar.gz" or ".tgz", the tarball is extracted first.
+
+A repository that does not exist is cloned from its bundle.  Otherwise, the
+branches of the bundle are fetched as the remote branches, and the local branch
+is fast-forwarded like the "sync" command does.  Either way, every remote is
+then set to its configured URL, so later syncs use the network as usual.
+
+By default, the configuration is read from standard input (stdin).  You can read
+from a file with the -f/--file flag.
+` + filterHelp),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if BundleFrom == "" {
+			return fmt.Errorf("bundle: %w", errs.ErrNoBundle)
+		}
+
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return errs.ErrHomeNotFound(err)
+		}
+
+		r, err := parseConfig(BundleFile)
+		if err != nil {
+			return fmt.Errorf("bundle: %w", err)
+		}
+
+		from := repos.ExpandHome(home, BundleFrom)
+
+		dir, cleanup, err := bundleDir(from)
+		if err != nil {
+			return fmt.Errorf("bundle: %w", err)
+		}
+
+		defer cleanup()
+
+		opts := repos.SyncOptions{
+			Jobs:         BundleJobs,
+			Bundles:      dir,
+			SwitchBranch: BundleSwitch,
+		}
+
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		return runSync(ctx, "bundle", r, opts)
+	},
+}
+
+// bundleDir returns the directory of bundles at the path, extracting it to a
+// temporary directory first when it is a tarball.  The cleanup function
+// removes the temporary directory.
+func bundleDir(path string) (dir string, cleanup func(), err error) {
+	archive, compressed := repos.IsArchive(path)
+	if !archive {
+		return path, func() {}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	defer file.Close()
+
+	dir, err = ioutil.TempDir("", "repos-bundle")
+	if err != nil {
+		return "", nil, err
+	}
+
+	cleanup = func() { os.RemoveAll(dir) }
+
+	if err := repos.ExtractArchive(file, dir, compressed); err != nil {
+		cleanup()
+
+		return "", nil, err
+	}
+
+	return dir, cleanup, nil
+}
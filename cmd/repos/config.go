@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/repos"
+)
+
+var (
+	RepoFilter repos.Filter // nolint: gochecknoglobals
+
+	// Retries is how many times a repository is tried again after a transient
+	// failure.
+	Retries     int         // nolint: gochecknoglobals
+	RetryPolicy repos.Retry // nolint: gochecknoglobals
+)
+
+// addFilterFlags adds the flags that select which repositories of the
+// configuration the command works on.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&RepoFilter.Tags, "tag", nil,
+		"only repositories with one of the tags")
+	cmd.Flags().StringSliceVar(&RepoFilter.ExcludeTags, "exclude-tag", nil,
+		"skip repositories with any of the tags")
+	cmd.Flags().StringSliceVar(&RepoFilter.Paths, "path", nil,
+		"only repositories matching one of the path globs")
+}
+
+// addRetryFlags adds the flags that set how transient git failures are
+// retried.
+func addRetryFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&Retries, "retries", 3,
+		"times to retry a repository after a transient failure")
+	cmd.Flags().DurationVar(&RetryPolicy.Delay, "retry-delay", time.Second,
+		"wait before the first retry, doubled for every next one")
+	cmd.Flags().DurationVar(&RetryPolicy.MaxDelay, "retry-max-delay",
+		30*time.Second, "longest wait before a retry")
+}
+
+// retry returns the retry policy set by the flags of addRetryFlags.
+func retry() repos.Retry {
+	policy := RetryPolicy
+	policy.Attempts = Retries + 1
+
+	return policy
+}
+
+// attemptsNote describes how many attempts were needed, when there was more
+// than one, to add to a log line.
+func attemptsNote(attempts int) string {
+	if attempts < 2 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (after %d attempts)", attempts)
+}
+
+// retryHelp describes the flags added by addRetryFlags for command help.
+const retryHelp = `
+Failures that may not happen again, such as a dropped connection, a timeout or
+a server error, are retried up to --retries times.  The first retry waits for
+--retry-delay, and every next one waits twice as long, up to --retry-max-delay.
+Failures such as a failed login or a missing repository are never retried.
+`
+
+// filterHelp describes the flags added by addFilterFlags for command help.
+const filterHelp = `
+Repositories can be selected with the --tag, --exclude-tag and --path flags.
+Tags come from section headers in the configuration:
+
+	[work]
+	~/src/work/api git@example.com:work/api.git
+
+	[oss personal]
+	~/src/oss/repos git@gitlab.com:kibafox/repos.git
+
+A path glob selects a repository when it matches the path or one of its parent
+directories, so --path '~/src/work' selects everything under that directory.
+Each flag can be repeated or given a comma-separated list.
+`
+
+// parseConfig reads the configuration from the file at path, or from standard
+// input when path is empty.  Lines that cannot be parsed are logged.  Only the
+// repositories selected by RepoFilter are returned.
+func parseConfig(path string) ([]repos.Repo, error) {
+	var input io.Reader
+
+	if path == "" {
+		input = os.Stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open repos file: %w", err)
+		}
+		defer f.Close()
+
+		input = f
+	}
+
+	var (
+		parseErrs = make(chan error, 1)
+		done      = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		for err := range parseErrs {
+			logErr("parse", err)
+		}
+	}()
+
+	r, err := repos.Parse(input, parseErrs)
+
+	<-done
+
+	if err != nil {
+		return r, err
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errs.ErrHomeNotFound(err)
+	}
+
+	filter := RepoFilter
+	filter.Paths = make([]string, len(RepoFilter.Paths))
+
+	for i, p := range RepoFilter.Paths {
+		filter.Paths[i] = repos.ExpandHome(home, p)
+	}
+
+	return filter.Apply(r)
+}
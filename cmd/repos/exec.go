@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/repos"
+)
+
+var (
+	ExecFile  string // nolint: gochecknoglobals
+	ExecJobs  int    // nolint: gochecknoglobals
+	ExecShell bool   // nolint: gochecknoglobals
+)
+
+func init() { // nolint: gochecknoinits
+	rootCmd.AddCommand(execCmd)
+	addFilterFlags(execCmd)
+	execCmd.Flags().StringVarP(&ExecFile, "file", "f", "",
+		"configuration file path (default: stdin)")
+	execCmd.Flags().IntVarP(&ExecJobs, "jobs", "j", runtime.NumCPU(),
+		"number of repositories to run the command in at the same time")
+	execCmd.Flags().BoolVarP(&ExecShell, "shell", "s", false,
+		"run the command with sh -c")
+}
+
+var execCmd = &cobra.Command{ // nolint: gochecknoglobals
+	Use:     "exec [flags] -- command [args ...]",
+	Aliases: []string{"foreach"},
+	Short:   "run a command in every repo from a configuration",
+	Long: strings.TrimSpace(`
+exec runs a command inside the local path of every git repository listed in the
+given configuration.
+
+The command and its arguments are run as given, without a shell.  Use "--" to
+stop flags meant for the command from being read by exec:
+
+	repos exec -f config.repo -- git log -1 --oneline
+
+With the -s/--shell flag the arguments are joined and run with "sh -c", which
+allows pipes and other shell syntax:
+
+	repos exec -f config.repo -s 'git branch | wc -l'
+
+Every line of output is prefixed with the path of the repository it came from.
+Commands run concurrently in several repositories.  The -j/--jobs flag limits
+how many run at the same time.  Once every command has finished, a summary of
+the non-zero exit codes is printed.
+
+With --output json or ndjson, a record with the exit code is written for every
+repository and the output of the commands goes to standard error.
+
+By default, the configuration is read from standard input (stdin).  You can read
+from a file with the -f/--file flag.
+` + filterHelp),
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		r, err := parseConfig(ExecFile)
+		if err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+
+		if ExecShell {
+			args = []string{"sh", "-c", strings.Join(args, " ")}
+		}
+
+		opts := repos.ExecOptions{
+			Jobs:   ExecJobs,
+			Stdout: cmd.OutOrStdout(),
+			Stderr: cmd.ErrOrStderr(),
+		}
+
+		// Standard output is kept for the records.
+		if structured() {
+			opts.Stdout = cmd.ErrOrStderr()
+		}
+
+		results, err := repos.Exec(ctx, r, args, opts)
+
+		attempted := make(map[string]bool, len(results))
+
+		for _, res := range results {
+			attempted[res.Repo.Path] = true
+
+			if structured() {
+				records.Record(newExecRecord(res))
+			}
+		}
+
+		notAttempted := logNotAttempted("exec", r, attempted)
+
+		if !structured() {
+			sErr := execSummary(cmd.ErrOrStderr(), results, notAttempted)
+			if sErr != nil {
+				return fmt.Errorf("exec: %w", sErr)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+
+		return nil
+	},
+}
+
+// execSummary writes the exit codes of the commands that failed to out, the
+// standard error of the command.  The repositories the command was not run in
+// are only counted.
+func execSummary(
+	out io.Writer,
+	results []repos.ExecResult,
+	notAttempted int,
+) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return errs.ErrHomeNotFound(err)
+	}
+
+	var failed int
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	for _, res := range results {
+		if res.Err == nil {
+			continue
+		}
+
+		if failed == 0 {
+			fmt.Fprintln(w, "\nEXIT\tPATH\tERROR")
+		}
+
+		failed++
+
+		code := "-"
+		if res.Code >= 0 {
+			code = fmt.Sprint(res.Code)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n",
+			code, repos.ContractHome(home, res.Repo.Path), res.Err)
+	}
+
+	fmt.Fprintf(w, "\n%d of %d failed%s\n", failed,
+		len(results)+notAttempted, notAttemptedNote(notAttempted))
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+
+	return nil
+}
@@ -1,24 +1,42 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gitlab.com/kibafox/repos/internal/errs"
 	"gitlab.com/kibafox/repos/internal/repos"
 )
 
-var ImportOut string // nolint: gochecknoglobals
+var (
+	ImportOut     string              // nolint: gochecknoglobals
+	ImportMerge   bool                // nolint: gochecknoglobals
+	ImportOptions repos.ImportOptions // nolint: gochecknoglobals
+)
 
 func init() { // nolint: gochecknoinits
 	rootCmd.AddCommand(importCmd)
 	importCmd.Flags().StringVarP(&ImportOut, "out", "o", "",
 		"destination file for the import (default: stdout)")
+	importCmd.Flags().BoolVarP(&ImportMerge, "merge", "m", false,
+		"append only new repositories to the --out configuration")
+	importCmd.Flags().StringArrayVar(&ImportOptions.Exclude, "exclude", nil,
+		"glob of paths to skip while searching (repeatable)")
+	importCmd.Flags().IntVar(&ImportOptions.MaxDepth, "max-depth", 0,
+		"how many directories deep to search (default: no limit)")
+	importCmd.Flags().IntVarP(&ImportOptions.Jobs, "jobs", "j",
+		runtime.NumCPU(), "number of directories to read at the same time")
 }
 
 var importCmd = &cobra.Command{ // nolint: gochecknoglobals
@@ -33,14 +51,53 @@ traversed recursively until a git repository is found.
 
 Repositories are detected when a path contains a directory named ".git".  The
 path found will become the PATH part of the config entry.  The git repository is
-inspected and the first remote named "origin" is used for the URL part of the
-config entry.
+inspected and the remote named "origin", or the first remote when there is no
+origin, is used for the URL part of the config entry.  Every other remote is
+kept as a remote.NAME=URL option.
+
+Bare repositories, directories with HEAD, objects and refs but no ".git", are
+imported with kind=bare.  Linked worktrees, where ".git" is a file pointing into
+the "worktrees" of another repository, are imported with kind=worktree.
+Submodules, where ".git" points into the "modules" of their parent, are only
+listed as comments since their parent repository manages them.
+
+Paths matching an --exclude glob are skipped along with everything below them.
+A glob without a slash, such as "node_modules", matches the name of any file or
+directory.  Otherwise it matches the whole path when it starts with "/" or "~",
+or the path relative to the directory being searched.  A ".reposignore" file
+found while searching lists more globs, one per line, relative to the directory
+it is in.  Empty lines and lines starting with "#" are ignored.
+
+Directories are read, and remotes looked up, concurrently.  The -j/--jobs flag
+limits how many are done at the same time.  The repositories found are sorted
+by path.
+
+The --max-depth flag limits how many directories below the directory being
+searched a repository can be found in.
 
 By default, the configuration is written to standard output (stdout).  You can
-write to a file with the -o/--out flag.
+write to a file with the -o/--out flag.  The file is replaced.
+
+With the -m/--merge flag, the -o/--out configuration is updated instead.  It is
+parsed, and only repositories at paths it does not list yet are appended under
+a comment with the date.  Existing lines and comments are left untouched.  A
+conflict is reported for every repository found at a listed path but with a
+different URL.  Repositories without a remote origin are not appended.  Paths
+are compared as absolute paths, with relative ones in the configuration taken
+from its directory.
+
+With --output json or ndjson, a record is written for every repository found
+instead of the configuration.
 `),
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		if ImportMerge {
+			return importMerge(ctx, args)
+		}
+
 		var output io.Writer
 
 		if ImportOut == "" {
@@ -53,7 +110,7 @@ write to a file with the -o/--out flag.
 
 			file, err := os.OpenFile(
 				repos.ExpandHome(home, ImportOut),
-				os.O_CREATE|os.O_WRONLY,
+				os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
 				0644,
 			)
 			if err != nil {
@@ -66,20 +123,23 @@ write to a file with the -o/--out flag.
 			output = file
 		}
 
+		if structured() {
+			records = &recorder{w: output}
+		}
+
 		paths := args
 		for i, path := range paths {
+			r, rErr := fromPath(ctx, path)
+			if rErr != nil {
+				return fmt.Errorf("import: %w", rErr)
+			}
 
-			errs := make(chan error, 1)
-
-			go func() {
-				for err := range errs {
-					log.Println(fmt.Errorf("import: %w", err))
+			if structured() {
+				for _, repo := range r {
+					records.Record(repoRecord{Type: "repo", Repo: repo})
 				}
-			}()
 
-			r, rErr := repos.FromPath(context.TODO(), path, errs)
-			if rErr != nil {
-				return fmt.Errorf("import: %w", rErr)
+				continue
 			}
 
 			_, iErr := output.Write(
@@ -102,6 +162,186 @@ write to a file with the -o/--out flag.
 			}
 		}
 
+		// The records need to be written before the output file is closed.
+		if err := records.Close(); err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+
 		return nil
 	},
 }
+
+// fromPath searches the path for repositories, logging any errors that do not
+// stop the search.
+func fromPath(ctx context.Context, path string) ([]repos.Repo, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errs.ErrHomeNotFound(err)
+	}
+
+	opts := ImportOptions
+	opts.Exclude = make([]string, len(ImportOptions.Exclude))
+
+	for i, p := range ImportOptions.Exclude {
+		opts.Exclude[i] = repos.ExpandHome(home, p)
+	}
+
+	var (
+		errCh = make(chan error, 1)
+		done  = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		for err := range errCh {
+			logErr("import", err)
+		}
+	}()
+
+	r, err := repos.FromPath(ctx, path, opts, errCh)
+
+	<-done
+
+	return r, err
+}
+
+// importMerge appends the repositories found in the paths that are not yet in
+// the configuration given by the out flag.
+func importMerge(ctx context.Context, paths []string) error {
+	if ImportOut == "" {
+		return fmt.Errorf("import: %w", errs.ErrMergeNoOut)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return errs.ErrHomeNotFound(err)
+	}
+
+	out := repos.ExpandHome(home, ImportOut)
+
+	content, err := ioutil.ReadFile(out)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("import: failed to read configuration: %w", err)
+	}
+
+	existing, err := parseExisting(content)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	// Relative paths in the configuration are taken from its directory, which
+	// need not be the one the paths are searched from.
+	for i, r := range existing {
+		if !filepath.IsAbs(r.Path) {
+			existing[i].Path = filepath.Join(filepath.Dir(out), r.Path)
+		}
+	}
+
+	file, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("import: failed to open file to write: %w", err)
+	}
+
+	defer file.Close()
+
+	for _, path := range paths {
+		found, err := fromPath(ctx, path)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+
+		added, conflicts, err := repos.Merge(existing, found)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+
+		existing = append(existing, added...)
+
+		for _, c := range conflicts {
+			logConflict(home, c)
+		}
+
+		if len(added) == 0 {
+			continue
+		}
+
+		for _, repo := range added {
+			if structured() {
+				records.Record(repoRecord{Type: "repo", Repo: repo})
+			}
+		}
+
+		comment := fmt.Sprintf("Imported Repositories from: %s on %s",
+			path, time.Now().Format("2006-01-02"))
+
+		// What is appended is kept as part of the content, so later paths
+		// are appended after it.
+		var buf bytes.Buffer
+
+		err = repos.AppendRepos(&buf, content, comment, added)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+
+		if _, err := file.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("import: failed to write repositories: %w", err)
+		}
+
+		content = append(content, buf.Bytes()...)
+	}
+
+	return nil
+}
+
+// parseExisting parses the existing configuration being merged into.  Lines
+// that cannot be parsed are logged and otherwise ignored.
+func parseExisting(content []byte) ([]repos.Repo, error) {
+	var (
+		parseErrs = make(chan error, 1)
+		done      = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		for err := range parseErrs {
+			logErr("parse", err)
+		}
+	}()
+
+	r, err := repos.Parse(bytes.NewReader(content), parseErrs)
+
+	<-done
+
+	if err != nil && !errors.Is(err, errs.ErrOccurred) {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type conflictRecord struct {
+	Type     string `json:"type"`
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+	FoundURL string `json:"found_url"`
+}
+
+// logConflict logs or records a repository found with a different URL than
+// the one configured.
+func logConflict(home string, c repos.Conflict) {
+	if structured() {
+		records.Record(conflictRecord{
+			Type:     "conflict",
+			Path:     c.Existing.Path,
+			URL:      c.Existing.URL,
+			FoundURL: c.Found.URL,
+		})
+
+		return
+	}
+
+	log.Printf("import: conflict: %s is configured with %s but has %s\n",
+		repos.ContractHome(home, c.Existing.Path), c.Existing.URL, c.Found.URL)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/repos"
+)
+
+var (
+	LockFile string // nolint: gochecknoglobals
+	LockOut  string // nolint: gochecknoglobals
+	LockJobs int    // nolint: gochecknoglobals
+)
+
+func init() { // nolint: gochecknoinits
+	rootCmd.AddCommand(lockCmd)
+	addFilterFlags(lockCmd)
+	lockCmd.Flags().StringVarP(&LockFile, "file", "f", "",
+		"configuration file path (default: stdin)")
+	lockCmd.Flags().StringVarP(&LockOut, "out", "o", "",
+		"destination file for the lockfile (default: stdout)")
+	lockCmd.Flags().IntVarP(&LockJobs, "jobs", "j", runtime.NumCPU(),
+		"number of repositories to inspect at the same time")
+}
+
+var lockCmd = &cobra.Command{ // nolint: gochecknoglobals
+	Use:   "lock",
+	Short: "record the exact commits of repos from a configuration",
+	Long: strings.TrimSpace(`
+lock writes a lockfile with the exact commit checked out in every git
+repository listed in the given configuration.
+
+The lockfile is a configuration like any other.  Each line has the branch= and
+commit= options set to what is checked out.  Restore a workspace to the locked
+commits with:
+
+	repos sync -f repos.lock --locked
+
+This makes it possible to reproduce a build across many repositories, or to go
+back in time to bisect a whole workspace.
+
+By default, the configuration is read from standard input (stdin) and the
+lockfile is written to standard output (stdout).  You can read from a file with
+the -f/--file flag and write to a file with the -o/--out flag.
+` + filterHelp),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		r, err := parseConfig(LockFile)
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+
+		var (
+			lockErrs = make(chan error, 1)
+			done     = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			for err := range lockErrs {
+				logErr("lock", err)
+			}
+		}()
+
+		locked, err := repos.Lock(ctx, r, LockJobs, lockErrs)
+
+		<-done
+
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+
+		if structured() {
+			for _, repo := range locked {
+				records.Record(repoRecord{Type: "repo", Repo: repo})
+			}
+
+			return nil
+		}
+
+		return writeLockfile(locked)
+	},
+}
+
+// writeLockfile writes the locked repos to the file given by the out flag, or
+// to stdout.
+func writeLockfile(locked []repos.Repo) error {
+	var output io.Writer = os.Stdout
+
+	if LockOut != "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return errs.ErrHomeNotFound(err)
+		}
+
+		file, err := os.Create(repos.ExpandHome(home, LockOut))
+		if err != nil {
+			return fmt.Errorf("lock: failed to open file to write: %w", err)
+		}
+
+		defer file.Close()
+
+		output = file
+	}
+
+	if err := repos.WriteRepos(locked, output); err != nil {
+		return fmt.Errorf("lock: %w", err)
+	}
+
+	return nil
+}
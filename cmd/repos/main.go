@@ -1,20 +1,42 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/git"
+	"gitlab.com/kibafox/repos/internal/repos"
 )
 
 const Version = "0.2.0"
 
-var Verbose bool // nolint: gochecknoglobals
+var (
+	Verbose     bool          // nolint: gochecknoglobals
+	Timeout     time.Duration // nolint: gochecknoglobals
+	RepoTimeout time.Duration // nolint: gochecknoglobals
+)
 
 var rootCmd = &cobra.Command{ // nolint: gochecknoglobals
 	Version: Version,
 	Use:     "repos",
 	Short:   "manage multiple git repositories",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		switch OutputFormat {
+		case outputText, outputJSON, outputNDJSON:
+			return nil
+		default:
+			return fmt.Errorf("%w: %s", errs.ErrOutputFormat, OutputFormat)
+		}
+	},
 	Long: strings.TrimSpace(`
 repos uses configurations that define a list of git repositories to in order to
 help you manage them.
@@ -22,24 +44,225 @@ help you manage them.
 Configurations are given in this format:
 
 	# Comment
-	PATH URL
+	PATH URL [KEY=VALUE ...]
 
 The PATH is the local file path of the repository.  The URL is the remote git
 repository to sync from.
 
+Optional KEY=VALUE fields change how a single repository is synced:
+
+	branch=NAME   branch to clone and keep checked out
+	depth=N       only clone and fetch the last N commits
+	filter=SPEC   partial clone filter, such as blob:none or tree:0
+	sparse=DIRS   comma separated directories for a sparse checkout
+	remote=NAME   name of the remote for the URL (default: origin)
+	remote.NAME=URL
+	              another remote to add and fetch, such as the upstream of a
+	              fork; can be given more than once
+	tags=false    do not fetch tags
+	commit=HASH   commit to check out with "sync --locked"
+	kind=KIND     "bare" for a repository without a working directory, or
+	              "worktree" for a linked worktree of another repository
+
+For example, to track the release branch of a large repository with a shallow
+clone:
+
+	~/src/big git@example.com:big.git branch=release depth=1
+
+A line such as "[work oss]" is a section header.  Every repository after it is
+tagged with "work" and "oss" until the next header.  The header "[]" stops
+tagging.  Tags are used to select repositories with the --tag and --exclude-tag
+flags.
+
 Configuration lines starting with '#' are ignored. Blank lines are also ignored.
 
 Configurations can either be hand crafted or imported with the "import" command.
+
+With --output json or --output ndjson, commands write structured records to
+standard output instead of text and log lines.  With json, all records are
+written as one array once the command is done.  With ndjson, each record is
+written on its own line as soon as it happens.  Every record has a "type" field
+naming what it describes, such as "sync", "status", "repo", "summary" or
+"error".
+
+The exit status tells why a command failed:
+
+	0   success
+	1   the command failed, such as for a bad flag or configuration
+	2   repositories failed in different or unrecognized ways
+	3   repositories failed to log in to their remote
+	4   remote repositories or refs were not found
+	5   remotes could not be reached, even after retrying
+	6   repositories need attention, such as uncommitted changes in the way
+	    of an update, merge conflicts or history that is not a fast-forward
+	7   the --timeout ran out, or git commands were killed by --repo-timeout
+	130 the command was interrupted
+
+The codes 3 to 6 are only used when every failed repository failed the same
+way.
+
+The --timeout flag stops a command that runs for longer, such as a sync stuck on
+a server that stopped answering.  Repositories being synced are left as git
+left them when it was killed.  The --repo-timeout flag limits each git command
+instead, failing only the repository it was run for.
+
+An interrupt, such as Ctrl-C, or a SIGTERM stops the command from starting on
+more repositories, and the repositories that completed, failed or were never
+attempted are reported.  A SIGTERM lets the repositories in progress finish.  A
+Ctrl-C in a terminal also interrupts the git commands in progress, so those
+repositories fail instead, and git removes the clones it did not finish.  A
+second interrupt stops the repositories still in progress right away.
 `),
 }
 
 func init() { // nolint: gochecknoinits
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false,
 		"verbose output")
+	rootCmd.PersistentFlags().StringVar(&OutputFormat, "output", outputText,
+		"output format: text, json or ndjson")
+	rootCmd.PersistentFlags().DurationVar(&Timeout, "timeout", 0,
+		"stop the whole command after this long (default: no limit)")
+	rootCmd.PersistentFlags().DurationVar(&RepoTimeout, "repo-timeout", 0,
+		"kill git commands running longer than this (default: no limit)")
+}
+
+// runContext returns the context for the command to run in, limited by the
+// --timeout and --repo-timeout flags.
+func runContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := git.WithTimeout(cmd.Context(), RepoTimeout)
+
+	if Timeout > 0 {
+		return context.WithTimeout(ctx, Timeout)
+	}
+
+	return context.WithCancel(ctx)
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+	ctx, cancel := interrupts(context.Background())
+	err := rootCmd.ExecuteContext(ctx)
+
+	cancel()
+
+	if cErr := records.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// interrupts returns a context that makes the first SIGINT or SIGTERM stop the
+// command from starting on more repositories, letting the ones in progress
+// finish.  The second one cancels the context, killing the git commands still
+// running.  A Ctrl-C in a terminal also interrupts those git commands directly,
+// and git removes a clone it did not finish.  Git is not moved to a process
+// group of its own to avoid that, since it could then no longer prompt for
+// credentials on the terminal.
+func interrupts(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(sigs)
+
+		select {
+		case <-sigs:
+		case <-ctx.Done():
+			return
+		}
+
+		log.Println("interrupted: starting no more repositories, " +
+			"interrupt again to stop the ones still in progress")
+		close(stop)
+
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return repos.WithStop(ctx, stop), cancel
+}
+
+// Exit codes of the command.
+const (
+	exitError    = 1
+	exitFailed   = 2
+	exitAuth     = 3
+	exitNotFound = 4
+	exitNetwork  = 5
+	exitConflict = 6
+	exitTimeout  = 7
+
+	// exitInterrupted is the exit code of a shell for a program stopped by
+	// SIGINT.
+	exitInterrupted = 130
+)
+
+// failureExits are the exit codes of the kinds of repository failures.
+var failureExits = map[string]int{ // nolint: gochecknoglobals
+	"auth":             exitAuth,
+	"not_found":        exitNotFound,
+	"network":          exitNetwork,
+	"dirty_worktree":   exitConflict,
+	"merge_conflict":   exitConflict,
+	"non_fast_forward": exitConflict,
+	"timeout":          exitTimeout,
+}
+
+// repoFailures is the error of a command where repositories failed, with the
+// failures counted by the errs.KindName of their error.
+type repoFailures struct {
+	err      error
+	failures map[string]int
+}
+
+func (e repoFailures) Error() string {
+	return e.err.Error()
+}
+
+func (e repoFailures) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for the error of a command.
+func exitCode(err error) int {
+	if errors.Is(err, errs.ErrInterrupted) {
+		return exitInterrupted
 	}
+
+	if errors.Is(err, errs.ErrContextTimeout) {
+		return exitTimeout
+	}
+
+	var failed repoFailures
+	if errors.As(err, &failed) {
+		code := 0
+
+		for kind := range failed.failures {
+			switch c, ok := failureExits[kind]; {
+			case !ok, code != 0 && c != code:
+				return exitFailed
+			default:
+				code = c
+			}
+		}
+
+		if code != 0 {
+			return code
+		}
+	}
+
+	if errors.Is(err, errs.ErrOccurred) {
+		return exitFailed
+	}
+
+	return exitError
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/repos"
+)
+
+var (
+	MirrorFile string // nolint: gochecknoglobals
+	MirrorDest string // nolint: gochecknoglobals
+	MirrorJobs int    // nolint: gochecknoglobals
+)
+
+func init() { // nolint: gochecknoinits
+	rootCmd.AddCommand(mirrorCmd)
+	addFilterFlags(mirrorCmd)
+	mirrorCmd.Flags().StringVarP(&MirrorFile, "file", "f", "",
+		"configuration file path (default: stdin)")
+	mirrorCmd.Flags().StringVarP(&MirrorDest, "dest", "d", "",
+		"directory to keep the mirrors in (required)")
+	mirrorCmd.Flags().IntVarP(&MirrorJobs, "jobs", "j", runtime.NumCPU(),
+		"number of mirrors to update at the same time")
+	addRetryFlags(mirrorCmd)
+}
+
+var mirrorCmd = &cobra.Command{ // nolint: gochecknoglobals
+	Use:   "mirror",
+	Short: "keep bare mirrors of repos from a configuration",
+	Long: strings.TrimSpace(`
+mirror keeps a bare mirror of every URL listed in the given configuration in
+the directory given by the -d/--dest flag.  This makes a backup of the remote
+repositories, with all of their branches and tags.
+
+Every URL, including those of remote.NAME= options, is mirrored once.  The
+mirror is kept at a path made of the host and path of the URL.  For example,
+"git@gitlab.com:kibafox/repos" is mirrored at DEST/gitlab.com/kibafox/repos.git.
+
+'git clone --mirror' is performed when the mirror does not exist.
+
+'git remote update --prune' is performed when it does.  Refs that were
+force-pushed or deleted upstream since the last update are logged, along with
+the commit they pointed to, so it can still be recovered from the mirror until
+git collects its garbage.
+
+By default, the configuration is read from standard input (stdin).  You can read
+from a file with the -f/--file flag.
+
+Mirrors are updated concurrently.  The -j/--jobs flag limits how many are
+updated at the same time.
+` + retryHelp + filterHelp),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		if MirrorDest == "" {
+			return fmt.Errorf("mirror: %w", errs.ErrNoDest)
+		}
+
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return errs.ErrHomeNotFound(err)
+		}
+
+		r, err := parseConfig(MirrorFile)
+		if err != nil {
+			return fmt.Errorf("mirror: %w", err)
+		}
+
+		var (
+			results = make(chan repos.MirrorResult, 1)
+			done    = make(chan struct{})
+			summary repos.MirrorSummary
+			start   = time.Now()
+		)
+
+		go func() {
+			defer close(done)
+
+			for res := range results {
+				summary.Add(res)
+				logMirrorResult(home, res)
+			}
+		}()
+
+		opts := repos.MirrorOptions{
+			Dest:  repos.ExpandHome(home, MirrorDest),
+			Jobs:  MirrorJobs,
+			Retry: retry(),
+		}
+
+		err = repos.Mirror(ctx, r, opts, results)
+
+		<-done
+
+		if structured() {
+			records.Record(
+				newMirrorSummaryRecord(summary, time.Since(start)))
+		} else {
+			log.Printf("mirror: %d mirrors in %s: %d cloned, %d updated, "+
+				"%d refs force-pushed or deleted, %d failed%s\n",
+				summary.Total(), time.Since(start).Round(time.Millisecond),
+				summary.Cloned, summary.Updated, summary.Changes,
+				summary.Failed, failuresNote(summary.Failures))
+		}
+
+		if err != nil {
+			return repoFailures{
+				err:      fmt.Errorf("mirror: %w", err),
+				failures: summary.Failures,
+			}
+		}
+
+		return nil
+	},
+}
+
+// logMirrorResult logs what happened when mirroring a URL, or records it when
+// the output is structured.
+func logMirrorResult(home string, res repos.MirrorResult) {
+	if structured() {
+		records.Record(newMirrorRecord(res))
+
+		return
+	}
+
+	path := repos.ContractHome(home, res.Repo.Path)
+	note := attemptsNote(res.Attempts)
+
+	if res.Err != nil {
+		log.Println(fmt.Errorf("mirror: %s %s%s: %w",
+			res.Action, path, note, repoErrCause(res.Err)))
+
+		return
+	}
+
+	log.Printf("mirror: %s %s%s\n", res.Action, path, note)
+
+	for _, c := range res.Changes {
+		if c.Deleted() {
+			log.Printf("mirror: %s: deleted %s, was %.7s\n",
+				path, c.Ref, c.Old)
+		} else {
+			log.Printf("mirror: %s: force-pushed %s, %.7s...%.7s\n",
+				path, c.Ref, c.Old, c.New)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/repos"
+)
+
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+var (
+	OutputFormat = outputText // nolint: gochecknoglobals
+
+	// records receives the structured records of the running command.
+	records = &recorder{w: os.Stdout} // nolint: gochecknoglobals
+)
+
+// structured checks if records should be written instead of text.
+func structured() bool {
+	return OutputFormat == outputJSON || OutputFormat == outputNDJSON
+}
+
+// recorder writes records as JSON.  With the ndjson format each record is
+// written on its own line as soon as it is recorded.  With the json format the
+// records are collected and written as one array on Close.
+type recorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	records []json.RawMessage
+	closed  bool
+}
+
+// Record writes or collects the record.  It is safe for concurrent use.
+func (rec *recorder) Record(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println(fmt.Errorf("output: failed to encode record: %w", err))
+
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if OutputFormat != outputNDJSON {
+		rec.records = append(rec.records, b)
+
+		return
+	}
+
+	if _, err := rec.w.Write(append(b, '\n')); err != nil {
+		log.Println(fmt.Errorf("output: failed to write record: %w", err))
+	}
+}
+
+// Close writes the collected records for the json format.  It is safe to call
+// more than once.
+func (rec *recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.closed || OutputFormat != outputJSON {
+		return nil
+	}
+
+	rec.closed = true
+
+	if rec.records == nil {
+		rec.records = []json.RawMessage{}
+	}
+
+	b, err := json.MarshalIndent(rec.records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("output: failed to encode records: %w", err)
+	}
+
+	if _, err := rec.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("output: failed to write records: %w", err)
+	}
+
+	return nil
+}
+
+// repoErrCause returns the failure of an errs.RepoError, since the operation
+// and path are already part of results and their log lines.  Other errors are
+// returned as they are.
+func repoErrCause(err error) error {
+	var repoErr *errs.RepoError
+	if errors.As(err, &repoErr) {
+		return repoErr.Err
+	}
+
+	return err
+}
+
+// failuresNote describes the failures counted by kind, such as
+// " (2 network, 1 auth)", to add to a summary log line.
+func failuresNote(failures map[string]int) string {
+	if len(failures) == 0 {
+		return ""
+	}
+
+	kinds := make([]string, 0, len(failures))
+	for kind := range failures {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Slice(kinds, func(i, j int) bool {
+		if failures[kinds[i]] != failures[kinds[j]] {
+			return failures[kinds[i]] > failures[kinds[j]]
+		}
+
+		return kinds[i] < kinds[j]
+	})
+
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%d %s", failures[kind], kind)
+	}
+
+	return " (" + strings.Join(kinds, ", ") + ")"
+}
+
+// logErr logs the error of the operation, or records it when the output is
+// structured.
+func logErr(op string, err error) {
+	if !structured() {
+		log.Println(fmt.Errorf("%s: %w", op, err))
+
+		return
+	}
+
+	records.Record(errorRecord{Type: "error", Op: op, Error: err.Error()})
+}
+
+type errorRecord struct {
+	Type  string `json:"type"`
+	Op    string `json:"op"`
+	Error string `json:"error"`
+}
+
+type repoRecord struct {
+	Type string `json:"type"`
+	repos.Repo
+}
+
+type syncRecord struct {
+	Type string `json:"type"`
+	repos.Repo
+	Action     repos.Action `json:"action"`
+	Reason     string       `json:"reason,omitempty"`
+	OldHead    string       `json:"old_head,omitempty"`
+	NewHead    string       `json:"new_head,omitempty"`
+	Updated    bool         `json:"updated"`
+	DurationMS int64        `json:"duration_ms"`
+	Attempts   int          `json:"attempts"`
+	Warnings   []string     `json:"warnings,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	ErrorKind  string       `json:"error_kind,omitempty"`
+}
+
+func newSyncRecord(res repos.Result) syncRecord {
+	rec := syncRecord{
+		Type:       "sync",
+		Repo:       res.Repo,
+		Action:     res.Action,
+		Reason:     res.Reason,
+		OldHead:    res.OldHead,
+		NewHead:    res.NewHead,
+		Updated:    res.Updated(),
+		DurationMS: res.Duration.Milliseconds(),
+		Attempts:   res.Attempts,
+		Warnings:   res.Warnings,
+	}
+
+	if res.Err != nil {
+		rec.Error = repoErrCause(res.Err).Error()
+		rec.ErrorKind = errs.KindName(res.Err)
+	}
+
+	return rec
+}
+
+type syncSummaryRecord struct {
+	Type         string         `json:"type"`
+	Total        int            `json:"total"`
+	Cloned       int            `json:"cloned"`
+	Updated      int            `json:"updated"`
+	Unchanged    int            `json:"unchanged"`
+	Failed       int            `json:"failed"`
+	Failures     map[string]int `json:"failures,omitempty"`
+	NotAttempted int            `json:"not_attempted"`
+	DurationMS   int64          `json:"duration_ms"`
+}
+
+func newSyncSummaryRecord(
+	s repos.SyncSummary,
+	d time.Duration,
+) syncSummaryRecord {
+	return syncSummaryRecord{
+		Type:       "summary",
+		Total:      s.Total(),
+		Cloned:     s.Cloned,
+		Updated:    s.Updated,
+		Unchanged:  s.Unchanged,
+		Failed:     s.Failed,
+		Failures:   s.Failures,
+		DurationMS: d.Milliseconds(),
+	}
+}
+
+type bundleSummaryRecord struct {
+	Type       string `json:"type"`
+	Total      int    `json:"total"`
+	Bundled    int    `json:"bundled"`
+	Skipped    int    `json:"skipped"`
+	Failed     int    `json:"failed"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type statusRecord struct {
+	Type string `json:"type"`
+	repos.Repo
+	Cloned   bool                `json:"cloned"`
+	Dirty    bool                `json:"dirty"`
+	Staged   bool                `json:"staged"`
+	Upstream bool                `json:"upstream"`
+	Ahead    uint                `json:"ahead"`
+	Behind   uint                `json:"behind"`
+	Branch   string              `json:"branch,omitempty"`
+	Origin   string              `json:"origin,omitempty"`
+	Drift    []repos.RemoteDrift `json:"drift,omitempty"`
+}
+
+func newStatusRecord(s repos.RepoStatus) statusRecord {
+	return statusRecord{
+		Type:     "status",
+		Repo:     s.Repo,
+		Cloned:   s.Cloned,
+		Dirty:    s.Dirty,
+		Staged:   s.Staged,
+		Upstream: s.Upstream,
+		Ahead:    s.Ahead,
+		Behind:   s.Behind,
+		Branch:   s.Branch,
+		Origin:   s.Origin,
+		Drift:    s.Drift,
+	}
+}
+
+type execRecord struct {
+	Type string `json:"type"`
+	repos.Repo
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+func newExecRecord(res repos.ExecResult) execRecord {
+	rec := execRecord{Type: "exec", Repo: res.Repo, Code: res.Code}
+
+	if res.Err != nil {
+		rec.Error = res.Err.Error()
+	}
+
+	return rec
+}
+
+type refChangeRecord struct {
+	Ref     string `json:"ref"`
+	Old     string `json:"old"`
+	New     string `json:"new,omitempty"`
+	Deleted bool   `json:"deleted"`
+}
+
+type mirrorRecord struct {
+	Type       string            `json:"type"`
+	Path       string            `json:"path"`
+	URL        string            `json:"url"`
+	Action     repos.Action      `json:"action"`
+	Changes    []refChangeRecord `json:"changes,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+	Attempts   int               `json:"attempts"`
+	Error      string            `json:"error,omitempty"`
+	ErrorKind  string            `json:"error_kind,omitempty"`
+}
+
+func newMirrorRecord(res repos.MirrorResult) mirrorRecord {
+	rec := mirrorRecord{
+		Type:       "mirror",
+		Path:       res.Repo.Path,
+		URL:        res.Repo.URL,
+		Action:     res.Action,
+		DurationMS: res.Duration.Milliseconds(),
+		Attempts:   res.Attempts,
+	}
+
+	for _, c := range res.Changes {
+		rec.Changes = append(rec.Changes, refChangeRecord{
+			Ref:     c.Ref,
+			Old:     c.Old,
+			New:     c.New,
+			Deleted: c.Deleted(),
+		})
+	}
+
+	if res.Err != nil {
+		rec.Error = repoErrCause(res.Err).Error()
+		rec.ErrorKind = errs.KindName(res.Err)
+	}
+
+	return rec
+}
+
+type mirrorSummaryRecord struct {
+	Type       string         `json:"type"`
+	Total      int            `json:"total"`
+	Cloned     int            `json:"cloned"`
+	Updated    int            `json:"updated"`
+	Changes    int            `json:"changes"`
+	Failed     int            `json:"failed"`
+	Failures   map[string]int `json:"failures,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+func newMirrorSummaryRecord(
+	s repos.MirrorSummary,
+	d time.Duration,
+) mirrorSummaryRecord {
+	return mirrorSummaryRecord{
+		Type:       "summary",
+		Total:      s.Total(),
+		Cloned:     s.Cloned,
+		Updated:    s.Updated,
+		Changes:    s.Changes,
+		Failed:     s.Failed,
+		Failures:   s.Failures,
+		DurationMS: d.Milliseconds(),
+	}
+}
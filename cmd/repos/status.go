@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/repos"
+)
+
+var (
+	StatusFile    string // nolint: gochecknoglobals
+	StatusJobs    int    // nolint: gochecknoglobals
+	StatusDirty   bool   // nolint: gochecknoglobals
+	StatusAhead   bool   // nolint: gochecknoglobals
+	StatusBehind  bool   // nolint: gochecknoglobals
+	StatusMissing bool   // nolint: gochecknoglobals
+	StatusDrift   bool   // nolint: gochecknoglobals
+)
+
+func init() { // nolint: gochecknoinits
+	rootCmd.AddCommand(statusCmd)
+	addFilterFlags(statusCmd)
+	statusCmd.Flags().StringVarP(&StatusFile, "file", "f", "",
+		"configuration file path (default: stdin)")
+	statusCmd.Flags().IntVarP(&StatusJobs, "jobs", "j", runtime.NumCPU(),
+		"number of repositories to inspect at the same time")
+	statusCmd.Flags().BoolVar(&StatusDirty, "dirty", false,
+		"show repositories with unstaged or staged changes")
+	statusCmd.Flags().BoolVar(&StatusAhead, "ahead", false,
+		"show repositories with commits not pushed upstream")
+	statusCmd.Flags().BoolVar(&StatusBehind, "behind", false,
+		"show repositories with upstream commits not pulled")
+	statusCmd.Flags().BoolVar(&StatusMissing, "missing", false,
+		"show repositories that have not been cloned")
+	statusCmd.Flags().BoolVar(&StatusDrift, "drift", false,
+		"show repositories with remotes on other URLs than configured")
+}
+
+var statusCmd = &cobra.Command{ // nolint: gochecknoglobals
+	Use:   "status",
+	Short: "show the status of repos from a configuration",
+	Long: strings.TrimSpace(`
+status inspects every git repository listed in the given configuration and
+prints a table with a row for each.  The columns are:
+
+	PATH    local path of the repository
+	STATE   "missing" when not cloned, otherwise "cloned"
+	DIRTY   "yes" when there are unstaged changes
+	STAGED  "yes" when there are changes staged for commit
+	AHEAD   commits not pushed upstream ("-" without an upstream)
+	BEHIND  upstream commits not pulled ("-" without an upstream)
+	BRANCH  checked out branch ("-" when HEAD is detached)
+	ORIGIN  URL of the remote (origin unless set with remote=)
+
+A remote with another URL than the one in the configuration, such as after a
+repository moved to another host, is marked with a "*" after its URL and a
+warning is logged.  'repos sync --fix-remotes' sets it to the configured URL.
+
+The --dirty, --ahead, --behind, --missing and --drift flags limit the table to
+matching repositories.  When more than one is given, a repository is shown if it
+matches any of them.  For example, to find work that would be lost:
+
+	repos status -f config.repo --dirty --ahead
+
+By default, the configuration is read from standard input (stdin).  You can read
+from a file with the -f/--file flag.
+` + filterHelp),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		r, err := parseConfig(StatusFile)
+		if err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+
+		statuses, err := repos.Status(ctx, r, StatusJobs)
+		if err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+
+		if structured() {
+			for _, s := range statuses {
+				if statusShown(s) {
+					records.Record(newStatusRecord(s))
+				}
+			}
+
+			return nil
+		}
+
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return errs.ErrHomeNotFound(err)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+
+		fmt.Fprintln(w,
+			"PATH\tSTATE\tDIRTY\tSTAGED\tAHEAD\tBEHIND\tBRANCH\tORIGIN")
+
+		for _, s := range statuses {
+			if !statusShown(s) {
+				continue
+			}
+
+			fmt.Fprintln(w, strings.Join(statusRow(home, s), "\t"))
+		}
+
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("status: failed to write table: %w", err)
+		}
+
+		for _, s := range statuses {
+			if !statusShown(s) {
+				continue
+			}
+
+			for _, d := range s.Drift {
+				log.Printf("status: warning: %s: %s\n", s.Repo.Path, d)
+			}
+		}
+
+		return nil
+	},
+}
+
+// statusShown checks the status against the filter flags.
+func statusShown(s repos.RepoStatus) bool {
+	if !StatusDirty && !StatusAhead && !StatusBehind && !StatusMissing &&
+		!StatusDrift {
+		return true
+	}
+
+	return (StatusDirty && (s.Dirty || s.Staged)) ||
+		(StatusAhead && s.Ahead > 0) ||
+		(StatusBehind && s.Behind > 0) ||
+		(StatusMissing && !s.Cloned) ||
+		(StatusDrift && len(s.Drift) > 0)
+}
+
+// statusRow formats the status as the columns of the status table.
+func statusRow(home string, s repos.RepoStatus) []string {
+	path := repos.ContractHome(home, s.Repo.Path)
+
+	if !s.Cloned {
+		return []string{path, "missing", "-", "-", "-", "-", "-", s.Repo.URL}
+	}
+
+	ahead, behind := "-", "-"
+	if s.Upstream {
+		ahead = strconv.FormatUint(uint64(s.Ahead), 10)
+		behind = strconv.FormatUint(uint64(s.Behind), 10)
+	}
+
+	branch := s.Branch
+	if branch == "" {
+		branch = "-"
+	}
+
+	origin := s.Origin
+	if origin == "" {
+		origin = "-"
+	}
+
+	if len(s.Drift) > 0 {
+		origin += "*"
+	}
+
+	return []string{
+		path, "cloned", yesNo(s.Dirty), yesNo(s.Staged),
+		ahead, behind, branch, origin,
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
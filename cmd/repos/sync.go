@@ -3,21 +3,55 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"runtime"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
+	"gitlab.com/kibafox/repos/internal/errs"
 	"gitlab.com/kibafox/repos/internal/repos"
 )
 
-var SyncFile string // nolint: gochecknoglobals
+var (
+	SyncFile   string // nolint: gochecknoglobals
+	SyncJobs   int    // nolint: gochecknoglobals
+	SyncDryRun bool   // nolint: gochecknoglobals
+	SyncLocked bool   // nolint: gochecknoglobals
+	SyncSwitch bool   // nolint: gochecknoglobals
+	SyncRepair bool   // nolint: gochecknoglobals
+	SyncFix    bool   // nolint: gochecknoglobals
+
+	// SyncDefaults are the options for repositories without their own.
+	SyncDefaults repos.Options // nolint: gochecknoglobals
+)
 
 func init() { // nolint: gochecknoinits
 	rootCmd.AddCommand(syncCmd)
+	addFilterFlags(syncCmd)
+	addRetryFlags(syncCmd)
 	syncCmd.Flags().StringVarP(&SyncFile, "file", "f", "",
 		"configuration file path (default: stdin)")
+	syncCmd.Flags().IntVarP(&SyncJobs, "jobs", "j", runtime.NumCPU(),
+		"number of repositories to sync at the same time")
+	syncCmd.Flags().BoolVarP(&SyncDryRun, "dry-run", "n", false,
+		"print what would be done without changing anything")
+	syncCmd.Flags().BoolVar(&SyncLocked, "locked", false,
+		"check out the commit= of each repository, as written by lock")
+	syncCmd.Flags().BoolVar(&SyncSwitch, "switch-branch", false,
+		"check out the branch= of repositories on another branch")
+	syncCmd.Flags().BoolVar(&SyncRepair, "repair", false,
+		"check repositories with 'git fsck' and reclone broken ones")
+	syncCmd.Flags().BoolVar(&SyncFix, "fix-remotes", false,
+		"set remotes on other URLs than configured to the configured URLs")
+	syncCmd.Flags().IntVar(&SyncDefaults.Depth, "depth", 0,
+		"depth= for repositories without one")
+	syncCmd.Flags().StringVar(&SyncDefaults.Filter, "filter", "",
+		"filter= for repositories without one, such as blob:none")
+	syncCmd.Flags().StringSliceVar(&SyncDefaults.Sparse, "sparse", nil,
+		"sparse= directories for repositories without any")
 }
 
 var syncCmd = &cobra.Command{ // nolint: gochecknoglobals
@@ -28,57 +62,296 @@ sync will clone, pull, or fetch changes for all the git repositories listed in
 the given configuration.
 
 'git clone' is performed when the local repository does not exist or is empty.
+A directory left behind by a clone that did not finish, holding nothing but a
+".git" directory without any refs, is removed and cloned again.  Clones are made
+in a temporary directory next to the repository and moved into place once they
+finish, so an interrupted clone leaves nothing behind.  A directory with other
+files but no git repository is never touched and fails with an error.
 
-'git pull' is performed when the local repository exists and there will be no
-conflicts to update the local working diretory to the latest changes.
+'git pull' is performed when the local repository exists, the working directory
+has no changes, and the local branch is only behind its upstream.  Only a
+fast-forward is ever done.
 
 'git fetch' is performed when the local repository exists and there are
-are potential conflicts to updating the local working directory state.
+potential conflicts to updating the local working directory state.  This is
+when there are changes in the working directory or staged for commit, or when
+the local branch has commits that are not upstream.
+
+The action taken for each repository is logged, along with the old and new
+commit when the local branch moved.  A summary of how many repositories were
+cloned, updated, unchanged or failed is logged at the end.
 
 By default, the configuration is read from standard input (stdin).  You can read
 from a file with the -f/--file flag.
-`),
+
+Repositories are synced concurrently.  The -j/--jobs flag limits how many are
+synced at the same time.
+
+A repository with a branch= option that has another branch checked out, or a
+detached HEAD, is only fetched and a warning is logged.  With the
+--switch-branch flag, the configured branch is checked out instead when the
+working directory has no changes.
+
+A remote with another URL than the one in the configuration, such as after a
+repository moved to another host, is still fetched from and a warning is
+logged.  With the --fix-remotes flag, it is set to the configured URL with 'git
+remote set-url' before fetching instead.
+
+The --depth, --filter and --sparse flags give the depth=, filter= and sparse=
+options of repositories that do not set their own.  A depth limits the history
+cloned and fetched.  A filter, such as "blob:none" or "tree:0", makes a partial
+clone that downloads the missing objects only when they are needed.  Sparse
+directories limit the working directory to those directories, and are set
+again on later syncs when they changed.  The filter only applies when cloning,
+since git remembers it for later fetches.
+
+With the --locked flag, repositories with a commit= option, such as those in a
+lockfile written by the "lock" command, are restored to that exact commit
+instead.  They are cloned or fetched as needed and HEAD is detached at the
+commit.  Repositories with uncommitted changes are not touched.
+
+With the -n/--dry-run flag nothing is changed.  Instead, a plan is printed with
+the action that would be taken for each repository and why.  Remotes are still
+contacted with 'git fetch --dry-run' to find out if there are changes.  A
+repository is planned as "skip" when there is nothing to do.
+
+A repository git cannot read fails with an error.  With the --repair flag, it
+is cloned again instead, and every repository is checked with 'git fsck' first
+so that missing or corrupt objects are found too.  The local branches that can
+still be read are fetched into the new clone, and the branch that was checked
+out is checked out again.  The broken repository is kept next to the new one,
+with a ".broken-TIME" suffix, for anything else worth saving.  Linked worktrees
+are never repaired.
+` + retryHelp + filterHelp),
 	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var input io.Reader
+		r, err := parseConfig(SyncFile)
+		if err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+
+		if SyncDryRun {
+			return syncPlan(cmd, r)
+		}
+
+		opts := repos.SyncOptions{
+			Jobs:         SyncJobs,
+			Locked:       SyncLocked,
+			SwitchBranch: SyncSwitch,
+			Repair:       SyncRepair,
+			FixRemotes:   SyncFix,
+			Defaults:     SyncDefaults,
+			Retry:        retry(),
+		}
+
+		ctx, cancel := runContext(cmd)
+		defer cancel()
+
+		return runSync(ctx, "sync", r, opts)
+	},
+}
+
+// runSync syncs the repos, logging or recording each result and a summary at
+// the end.  Errors are prefixed with op.
+func runSync(
+	ctx context.Context,
+	op string,
+	r []repos.Repo,
+	opts repos.SyncOptions,
+) error {
+	var (
+		results   = make(chan repos.Result, 1)
+		done      = make(chan struct{})
+		summary   repos.SyncSummary
+		attempted = make(map[string]bool, len(r))
+		start     = time.Now()
+	)
+
+	go func() {
+		defer close(done)
+
+		for res := range results {
+			attempted[res.Repo.Path] = true
+
+			summary.Add(res)
+			logResult(op, res)
+		}
+	}()
+
+	err := repos.Sync(ctx, r, opts, results)
+
+	<-done
+
+	notAttempted := logNotAttempted(op, r, attempted)
+
+	if structured() {
+		rec := newSyncSummaryRecord(summary, time.Since(start))
+		rec.Total += notAttempted
+		rec.NotAttempted = notAttempted
+		records.Record(rec)
+	} else {
+		log.Printf("%s: %d repositories in %s: "+
+			"%d cloned, %d updated, %d unchanged, %d failed%s%s\n",
+			op, summary.Total()+notAttempted,
+			time.Since(start).Round(time.Millisecond),
+			summary.Cloned, summary.Updated, summary.Unchanged,
+			summary.Failed, failuresNote(summary.Failures),
+			notAttemptedNote(notAttempted))
+	}
+
+	if err != nil {
+		return repoFailures{
+			err:      fmt.Errorf("%s: %w", op, err),
+			failures: summary.Failures,
+		}
+	}
+
+	return nil
+}
 
-		if SyncFile == "" {
-			input = os.Stdin
+// logNotAttempted logs or records every repository without a result, such as
+// when the op was interrupted, and returns how many there were.
+func logNotAttempted(op string, r []repos.Repo, attempted map[string]bool) int {
+	count := 0
+
+	for _, repo := range r {
+		if attempted[repo.Path] {
+			continue
+		}
+
+		count++
+
+		if structured() {
+			records.Record(repoRecord{Type: "not_attempted", Repo: repo})
 		} else {
-			f, err := os.Open(SyncFile)
-			if err != nil {
-				return fmt.Errorf("sync: failed to open repos file: %w", err)
-			}
-			defer f.Close()
+			log.Printf("%s: not attempted %s\n", op, repo.Path)
+		}
+	}
+
+	return count
+}
+
+// notAttemptedNote describes how many repositories were not attempted, when
+// there were any, to add to a summary log line.
+func notAttemptedNote(count int) string {
+	if count == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(", %d not attempted", count)
+}
+
+// logResult logs what the op did to a repository, or records it when the
+// output is structured.
+func logResult(op string, res repos.Result) {
+	if structured() {
+		records.Record(newSyncRecord(res))
+
+		return
+	}
+
+	for _, w := range res.Warnings {
+		log.Printf("%s: warning: %s: %s\n", op, res.Repo.Path, w)
+	}
+
+	note := attemptsNote(res.Attempts)
+
+	switch {
+	case res.Err != nil:
+		log.Println(fmt.Errorf("%s: %s %s%s: %w",
+			op, res.Action, res.Repo.Path, note, repoErrCause(res.Err)))
+	case res.Updated() && res.OldHead != "":
+		log.Printf("%s: %s %s: %.7s..%.7s%s\n",
+			op, res.Action, res.Repo.Path, res.OldHead, res.NewHead, note)
+	default:
+		log.Printf("%s: %s %s%s\n", op, res.Action, res.Repo.Path, note)
+	}
+}
+
+// syncPlan does a dry run sync and prints the plan in the order of the
+// configuration.
+func syncPlan(cmd *cobra.Command, r []repos.Repo) error {
+	var (
+		results = make(chan repos.Result, 1)
+		done    = make(chan struct{})
+		planned = make(map[string]repos.Result, len(r))
+	)
 
-			input = f
+	go func() {
+		defer close(done)
+
+		for res := range results {
+			planned[res.Repo.Path] = res
 		}
+	}()
 
-		parseErrs := make(chan error, 1)
+	opts := repos.SyncOptions{
+		Jobs:         SyncJobs,
+		DryRun:       true,
+		Locked:       SyncLocked,
+		SwitchBranch: SyncSwitch,
+		Repair:       SyncRepair,
+		FixRemotes:   SyncFix,
+		Defaults:     SyncDefaults,
+		Retry:        retry(),
+	}
 
-		go func() {
-			for err := range parseErrs {
-				log.Println(fmt.Errorf("parse: %w", err))
-			}
-		}()
+	ctx, cancel := runContext(cmd)
+	defer cancel()
 
-		r, err := repos.Parse(input, parseErrs)
-		if err != nil {
-			return fmt.Errorf("sync: %w", err)
+	err := repos.Sync(ctx, r, opts, results)
+
+	<-done
+
+	home, hErr := os.UserHomeDir()
+	if hErr != nil {
+		return errs.ErrHomeNotFound(hErr)
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+
+	for _, repo := range r {
+		res, ok := planned[repo.Path]
+		if !ok {
+			continue
 		}
 
-		syncErrs := make(chan error, 1)
+		if structured() {
+			rec := newSyncRecord(res)
+			rec.Type = "plan"
+			records.Record(rec)
 
-		go func() {
-			for err := range syncErrs {
-				log.Println(fmt.Errorf("sync: %w", err))
-			}
-		}()
+			continue
+		}
 
-		if err := repos.Sync(context.TODO(), r, syncErrs); err != nil {
-			return fmt.Errorf("sync: %w", err)
+		for _, warning := range res.Warnings {
+			log.Printf("sync: warning: %s: %s\n", repo.Path, warning)
 		}
 
-		return nil
-	},
+		fmt.Fprintln(w, strings.Join(planRow(home, res), "\t"))
+	}
+
+	if fErr := w.Flush(); fErr != nil {
+		return fmt.Errorf("sync: failed to write plan: %w", fErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+
+	return nil
+}
+
+// planRow formats a dry run result as the columns of the plan.
+func planRow(home string, res repos.Result) []string {
+	path := repos.ContractHome(home, res.Repo.Path)
+
+	switch {
+	case res.Err != nil:
+		return []string{"error", path, repoErrCause(res.Err).Error()}
+	case res.Action == repos.ActionClone:
+		return []string{string(res.Action), path, "from " + res.Repo.URL}
+	default:
+		return []string{string(res.Action), path, res.Reason}
+	}
 }
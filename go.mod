@@ -1,6 +1,6 @@
 module gitlab.com/kibafox/repos
 
-go 1.13
+go 1.16
 
 require (
 	github.com/kr/text v0.2.0 // indirect
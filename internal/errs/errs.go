@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 )
 
 var (
@@ -14,6 +16,10 @@ var (
 	// ErrContextTimeout occurs when the context deadline has exceded.
 	ErrContextTimeout = errors.New("timed out")
 
+	// ErrInterrupted occurs when work was stopped early, such as by Ctrl-C,
+	// before every repository was handled.
+	ErrInterrupted = errors.New("interrupted")
+
 	// ErrNilChan occurs when an uninitialized channel has been provided.
 	ErrNilChan = errors.New("channel not initialized")
 
@@ -22,10 +28,94 @@ var (
 
 	// ErrParseLine occurs when parsing configuration that does not match the
 	// correct format.
-	ErrParseLine = errors.New("needs to formatted: PATH REMOTE")
+	ErrParseLine = errors.New("needs to formatted: PATH REMOTE [KEY=VALUE ...]")
+
+	// ErrUnknownOption occurs when a repository option is not supported.
+	ErrUnknownOption = errors.New("unknown option")
+
+	// ErrOptionValue occurs when a repository option has an invalid value.
+	ErrOptionValue = errors.New("invalid option value")
 
 	// ErrGit occurs when running git has a failure.
 	ErrGit = errors.New("error running git")
+
+	// ErrTransient occurs along with ErrGit when git failed in a way that may
+	// not happen again, such as a dropped network connection.
+	ErrTransient = errors.New("transient failure")
+
+	// ErrAuth occurs along with ErrGit when git failed to log in to a remote.
+	ErrAuth = errors.New("authentication failed")
+
+	// ErrNotFound occurs along with ErrGit when a remote repository or ref
+	// does not exist.
+	ErrNotFound = errors.New("remote repository not found")
+
+	// ErrNonFastForward occurs along with ErrGit when a branch or tag cannot
+	// be updated without losing commits.
+	ErrNonFastForward = errors.New("not a fast-forward")
+
+	// ErrNetwork occurs along with ErrGit and ErrTransient when git failed to
+	// reach a remote, or the remote failed to answer.
+	ErrNetwork = errors.New("network failure")
+
+	// ErrMergeConflict occurs along with ErrGit when there are conflicts to
+	// resolve.
+	ErrMergeConflict = errors.New("merge conflict")
+
+	// ErrGitTimeout occurs along with ErrGit when a git command took longer
+	// than allowed and was killed.
+	ErrGitTimeout = errors.New("git command timed out")
+
+	// ErrNoCommand occurs when there is no command to execute.
+	ErrNoCommand = errors.New("no command given")
+
+	// ErrDirtyWorktree occurs when a repository has uncommitted changes that
+	// would be lost.
+	ErrDirtyWorktree = errors.New("working directory has uncommitted changes")
+
+	// ErrNotRepo occurs when the path of a repository is a directory with
+	// files but no git repository, which cloning would mix with.
+	ErrNotRepo = errors.New("directory is not a git repository")
+
+	// ErrBrokenRepo occurs when git cannot read a local repository.
+	ErrBrokenRepo = errors.New(
+		"repository is broken; repair it with 'sync --repair'")
+
+	// ErrUnreadableBranches occurs when repairing a repository whose local
+	// branches cannot be listed, since they would be lost.
+	ErrUnreadableBranches = errors.New(
+		"local branches cannot be read to keep them; repair it by hand")
+
+	// ErrNotCloned occurs when a local repository does not exist.
+	ErrNotCloned = errors.New("repository not cloned")
+
+	// ErrExcludePattern occurs when an exclude pattern is malformed.
+	ErrExcludePattern = errors.New("malformed exclude pattern")
+
+	// ErrGitFile occurs when a ".git" file does not point to a git directory.
+	ErrGitFile = errors.New(`".git" file has no "gitdir:" line`)
+
+	// ErrWorktreeMissing occurs when a configured linked worktree does not
+	// exist, since it cannot be cloned.
+	ErrWorktreeMissing = errors.New(
+		"linked worktree does not exist; create it with 'git worktree add'")
+
+	// ErrNoDest occurs when mirroring without a destination directory.
+	ErrNoDest = errors.New("no destination directory given")
+
+	// ErrNoBundle occurs when there is no bundle for a repository.
+	ErrNoBundle = errors.New("no bundle found")
+
+	// ErrArchivePath occurs when a file in an archive would be extracted
+	// outside of the destination directory.
+	ErrArchivePath = errors.New("archive file path leaves the directory")
+
+	// ErrMergeNoOut occurs when merging an import without a file to merge
+	// into.
+	ErrMergeNoOut = errors.New("merging needs a file given with --out")
+
+	// ErrOutputFormat occurs when an unsupported output format is requested.
+	ErrOutputFormat = errors.New("unknown output format")
 )
 
 // ErrHomeNotFound occurs when there is an error using os.UserHomeDir().
@@ -33,12 +123,196 @@ func ErrHomeNotFound(err error) error {
 	return fmt.Errorf("error finding home directory: %w", err)
 }
 
-// NewErrGit creats a new Git error.
-func NewErrGit(stderr fmt.Stringer, cmdArgs ...string) error {
-	return fmt.Errorf("%w %s:\n%s", ErrGit,
-		strings.Join(cmdArgs, " "),
-		strings.TrimSuffix(stderr.String(), "\n"),
-	)
+// GitError is a failure running git, with what git wrote to standard error.
+// It matches ErrGit with errors.Is, and its Kind as well.  A failure of the
+// ErrNetwork kind also matches ErrTransient.
+type GitError struct {
+	Args   []string
+	Stderr string
+	// ExitCode is the exit code of git, or -1 when git did not exit normally,
+	// such as when it was killed.
+	ExitCode int
+	// Kind is the error of the kind of failure, such as ErrAuth or
+	// ErrNetwork, or nil when the failure is not recognized.
+	Kind error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("%s %s:\n%s", ErrGit, strings.Join(e.Args, " "),
+		e.Stderr)
+}
+
+// Is reports if the target is ErrGit, the kind of the failure, or
+// ErrTransient for a failure that may not happen again.
+func (e *GitError) Is(target error) bool {
+	switch {
+	case target == ErrGit:
+		return true
+	case target == ErrTransient:
+		return e.Kind == ErrNetwork
+	default:
+		return e.Kind != nil && target == e.Kind
+	}
+}
+
+// NewErrGit creats a new Git error, classifying the failure from the exit code
+// and what git wrote to standard error.
+func NewErrGit(exitCode int, stderr string, cmdArgs ...string) error {
+	return &GitError{
+		Args:     cmdArgs,
+		Stderr:   stderr,
+		ExitCode: exitCode,
+		Kind:     gitKind(exitCode, stderr),
+	}
+}
+
+// NewErrGitTimeout creates a new Git error for a command killed after running
+// for longer than the timeout.
+func NewErrGitTimeout(timeout time.Duration, cmdArgs ...string) error {
+	return &GitError{
+		Args:     cmdArgs,
+		Stderr:   fmt.Sprintf("killed after %s", timeout),
+		ExitCode: -1,
+		Kind:     ErrGitTimeout,
+	}
+}
+
+// IsTransient reports if the error is a git failure that may not happen again.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// Kind returns the error of the kind of failure the error is, such as ErrAuth,
+// or nil when it is none of them.
+func Kind(err error) error {
+	for _, k := range gitKinds {
+		if errors.Is(err, k.err) {
+			return k.err
+		}
+	}
+
+	return nil
+}
+
+// KindName returns a short name for the kind of failure the error is, such as
+// "auth", "network" or "timeout", or "other" when it is none of them.
+func KindName(err error) string {
+	for _, k := range gitKinds {
+		if errors.Is(err, k.err) {
+			return k.name
+		}
+	}
+
+	return "other"
+}
+
+type gitKindMatch struct {
+	err  error
+	name string
+	re   *regexp.Regexp
+}
+
+// gitKinds match what git writes to standard error to the kind of failure.
+// They are checked in order, since git may also report a hung up connection
+// after a failed login or a missing repository.  The kinds without messages
+// are never parsed, only named.
+var gitKinds = []gitKindMatch{ // nolint: gochecknoglobals
+	{ErrGitTimeout, "timeout", nil},
+	{ErrContextTimeout, "timeout", nil},
+	{ErrContextCanceled, "canceled", nil},
+	{ErrInterrupted, "interrupted", nil},
+	{ErrAuth, "auth", gitMessages(
+		`authentication failed`,
+		`permission denied`,
+		`could not read (username|password)`,
+		`host key verification failed`,
+		`returned error: 40[13]`,
+	)},
+	{ErrNotFound, "not_found", gitMessages(
+		`repository .* not found`,
+		`repository .* does not exist`,
+		`does not appear to be a git repository`,
+		`couldn't find remote ref`,
+		`returned error: 404`,
+	)},
+	{ErrDirtyWorktree, "dirty_worktree", gitMessages(
+		`local changes to the following files would be overwritten`,
+		`untracked working tree files would be`,
+		`please commit your changes or stash them`,
+		`you have unstaged changes`,
+	)},
+	{ErrMergeConflict, "merge_conflict", gitMessages(
+		`you have unmerged files`,
+		`unresolved conflict`,
+		`resolve your current index first`,
+		`conflict \(`,
+	)},
+	{ErrNonFastForward, "non_fast_forward", gitMessages(
+		`non-fast-forward`,
+		`not possible to fast-forward`,
+		`\[rejected\]`,
+		`would clobber existing tag`,
+	)},
+	{ErrNetwork, "network", gitMessages(
+		`connection (reset|refused|timed out|closed)`,
+		`timed out`,
+		`timeout`,
+		`early eof`,
+		`the remote end hung up unexpectedly`,
+		`unexpected disconnect`,
+		`rpc failed`,
+		`returned error: 5\d\d`,
+		`http 5\d\d`,
+		`failed to connect`,
+		`couldn't connect to server`,
+		`could not resolve host`,
+		`temporary failure in name resolution`,
+		`broken pipe`,
+		`ssl_read`,
+		`gnutls`,
+	)},
+}
+
+// gitMessages matches any of the patterns, ignoring case.
+func gitMessages(patterns ...string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)` + strings.Join(patterns, "|"))
+}
+
+// gitKind classifies a failure of git.  Git exits with 128, or 129 for usage
+// errors, when it dies on an error, and with 1 when a command such as a merge
+// fails.  Git that did not exit on its own, such as when it was killed because
+// the context was canceled, is never classified.
+func gitKind(exitCode int, stderr string) error {
+	if exitCode < 0 {
+		return nil
+	}
+
+	for _, k := range gitKinds {
+		if k.re != nil && k.re.MatchString(stderr) {
+			return k.err
+		}
+	}
+
+	return nil
+}
+
+// RepoError is a failure of an operation on a repository, such as a clone.
+type RepoError struct {
+	Path string
+	URL  string
+	// Op is what was being done when the failure happened, such as "clone" or
+	// "fetch".
+	Op  string
+	Err error
+}
+
+func (e *RepoError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Path, e.Err)
+}
+
+// Unwrap returns the failure.
+func (e *RepoError) Unwrap() error {
+	return e.Err
 }
 
 // ErrContext occurs when there is a context error that is not a canecelation or
@@ -3,63 +3,349 @@ package git
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"gitlab.com/kibafox/repos/internal/errs"
 )
 
 const gitCmd = "git"
 
-// Run will run git with the provided arguments.
-func Run(ctx context.Context, args ...string) error {
-	bufErr := &bytes.Buffer{}
+type timeoutKey struct{}
+
+// WithTimeout returns a context that limits how long every git command run
+// with it may take.  A git command that takes longer is killed and fails with
+// errs.ErrGitTimeout.  A timeout of zero or less sets no limit.
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, timeout)
+}
+
+// run runs git, writing its standard output to stdout when it is not nil.  It
+// returns what git wrote to standard error.
+func run(
+	ctx context.Context,
+	stdout io.Writer,
+	args ...string,
+) (string, error) {
+	cmdCtx := ctx
+
+	timeout, _ := ctx.Value(timeoutKey{}).(time.Duration)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Standard error is read from a pipe, rather than copied into a buffer by
+	// exec, so that processes started by git, such as remote helpers, cannot
+	// keep a killed git from returning by holding on to it.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+
+	defer pr.Close()
+
+	read := make(chan []byte, 1)
+
+	go func() {
+		out, _ := ioutil.ReadAll(pr)
+		read <- out
+	}()
+
+	cmd := exec.CommandContext(cmdCtx, gitCmd, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = pw
+
+	err = cmd.Run()
 
-	cmd := exec.CommandContext(ctx, gitCmd, args...)
-	cmd.Stderr = bufErr
+	pw.Close()
 
-	if err := cmd.Run(); err != nil {
-		return errs.NewErrGit(bufErr, args...)
+	if cmdCtx.Err() != nil {
+		pr.Close()
 	}
 
-	return nil
+	stderr := strings.TrimSuffix(string(<-read), "\n")
+
+	if err != nil {
+		// Only this command timed out when the context given is not done.
+		if cmdCtx.Err() != nil && ctx.Err() == nil {
+			return "", errs.NewErrGitTimeout(timeout, args...)
+		}
+
+		return "", errs.NewErrGit(exitCode(err), stderr, args...)
+	}
+
+	return stderr, nil
+}
+
+// Run will run git with the provided arguments.
+func Run(ctx context.Context, args ...string) error {
+	_, err := run(ctx, nil, args...)
+
+	return err
 }
 
 // Out will run git with the provided arguments and return the captured output.
 func Out(ctx context.Context, args ...string) (string, error) {
 	bufOut := &bytes.Buffer{}
-	bufErr := &bytes.Buffer{}
-
-	cmd := exec.CommandContext(ctx, gitCmd, args...)
-	cmd.Stdout = bufOut
-	cmd.Stderr = bufErr
 
-	if err := cmd.Run(); err != nil {
-		return "", errs.NewErrGit(bufErr, args...)
+	if _, err := run(ctx, bufOut, args...); err != nil {
+		return "", err
 	}
 
 	return strings.TrimSuffix(bufOut.String(), "\n"), nil
 }
 
+// ErrOut will run git with the provided arguments and return what was written
+// to standard error.  Some git commands, like `git fetch`, report their
+// progress there.
+func ErrOut(ctx context.Context, args ...string) (string, error) {
+	return run(ctx, nil, args...)
+}
+
+// exitCode returns the exit code of git from the error of running it, or -1
+// when git did not exit normally or could not be started.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
 func bol(ctx context.Context, args ...string) bool {
-	cmd := exec.CommandContext(ctx, gitCmd, args...)
-	if err := cmd.Run(); err != nil {
+	if _, err := run(ctx, nil, args...); err != nil {
 		return false
 	}
 
 	return true
 }
 
-func Clone(ctx context.Context, remote, local string) error {
-	return Run(ctx, "clone", "--quiet", remote, local)
+// Clone clones the remote repository into the local path.  Any extra
+// arguments are given to `git clone` before the remote.
+func Clone(ctx context.Context, remote, local string, args ...string) error {
+	cloneArgs := append([]string{"clone", "--quiet"}, args...)
+
+	return Run(ctx, append(cloneArgs, "--", remote, local)...)
+}
+
+// Fetch downloads objects and refs from a remote without changing the working
+// directory.  Any extra arguments, such as the remote name, are given to
+// `git fetch`.
+func Fetch(ctx context.Context, path string, args ...string) error {
+	fetchArgs := append([]string{"-C", path, "fetch", "--quiet"}, args...)
+
+	return Run(ctx, fetchArgs...)
+}
+
+// Switch checks out the branch.  A missing local branch is created from the
+// remote branch of the same name.
+func Switch(ctx context.Context, path, branch string) error {
+	return Run(ctx, "-C", path, "checkout", "--quiet", branch, "--")
+}
+
+// SparseCheckout limits the working directory to the directories, turning on a
+// cone mode sparse checkout when needed.
+func SparseCheckout(ctx context.Context, path string, dirs ...string) error {
+	err := Run(ctx, "-C", path, "sparse-checkout", "init", "--cone")
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"-C", path, "sparse-checkout", "set"}, dirs...)
+
+	return Run(ctx, args...)
+}
+
+// SparseDirs returns the directories of a sparse checkout.  It fails when the
+// checkout is not sparse.
+func SparseDirs(ctx context.Context, path string) ([]string, error) {
+	out, err := Out(ctx, "-C", path, "sparse-checkout", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	if out == "" {
+		return nil, nil
+	}
+
+	return strings.Split(out, "\n"), nil
 }
 
-func Pull(ctx context.Context, path string) error {
-	return Run(ctx, "-C", path, "pull", "--ff-only", "--quiet")
+// RemoteUpdate fetches every remote, deleting refs that no longer exist on the
+// remote.
+func RemoteUpdate(ctx context.Context, path string) error {
+	return Run(ctx, "-C", path, "remote", "update", "--prune")
+}
+
+// Refs returns the commit each ref points to, keyed by the full ref name.
+func Refs(ctx context.Context, path string) (map[string]string, error) {
+	out, err := Out(ctx, "-C", path,
+		"for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string)
+
+	for _, line := range strings.Split(out, "\n") {
+		if n := strings.Index(line, " "); n > 0 {
+			refs[line[n+1:]] = line[:n]
+		}
+	}
+
+	return refs, nil
+}
+
+// IsAncestor checks if the commit is an ancestor of, or the same as, another.
+func IsAncestor(ctx context.Context, path, commit, of string) bool {
+	return bol(ctx, "-C", path, "merge-base", "--is-ancestor", commit, of)
+}
+
+// Checkout detaches HEAD at the given commit.
+func Checkout(ctx context.Context, path, commit string) error {
+	return Run(ctx, "-C", path, "checkout", "--quiet", "--detach", commit)
+}
+
+// FetchDryRun returns the refs fetching from a remote would update, without
+// changing anything.  Refs are named the way git reports them, such as
+// "origin/main" for a remote tracking branch, to compare with Upstream.  Any
+// extra arguments, such as the remote name, are given to `git fetch`.
+func FetchDryRun(
+	ctx context.Context,
+	path string,
+	args ...string,
+) ([]string, error) {
+	fetchArgs := append([]string{"-C", path, "fetch", "--dry-run"}, args...)
+
+	output, err := ErrOut(ctx, fetchArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+
+	// Every ref that would be updated is reported as "OLD..NEW  SRC -> DST",
+	// which may be followed by a note such as "(forced update)".
+	for _, line := range strings.Split(output, "\n") {
+		n := strings.Index(line, " -> ")
+		if n < 0 {
+			continue
+		}
+
+		if dst := strings.Fields(line[n+4:]); len(dst) > 0 {
+			refs = append(refs, dst[0])
+		}
+	}
+
+	return refs, nil
+}
+
+// Upstream returns the short name of the upstream of the checked out branch,
+// such as "origin/main".
+func Upstream(ctx context.Context, path string) (string, error) {
+	return Out(ctx, "-C", path, "rev-parse", "--abbrev-ref", "@{upstream}")
+}
+
+// FastForward updates the current branch to its already fetched upstream.  It
+// fails rather than creating a merge commit.
+func FastForward(ctx context.Context, path string) error {
+	return Run(ctx, "-C", path, "merge", "--ff-only", "--quiet", "@{upstream}")
 }
 
 func Origin(ctx context.Context, path string) (string, error) {
-	return Out(ctx, "-C", path, "remote", "get-url", "origin")
+	return RemoteURL(ctx, path, "origin")
+}
+
+// Head returns the commit hash HEAD points to.
+func Head(ctx context.Context, path string) (string, error) {
+	return Out(ctx, "-C", path, "rev-parse", "--verify", "--quiet", "HEAD")
+}
+
+// RemoteURL returns the URL of the named remote.
+func RemoteURL(ctx context.Context, path, name string) (string, error) {
+	return Out(ctx, "-C", path, "remote", "get-url", name)
+}
+
+// Remote is a named remote repository.
+type Remote struct {
+	Name string
+	URL  string
+}
+
+// Remotes returns the remotes of the repository in the order they are
+// configured.  It fails when there are none.
+func Remotes(ctx context.Context, path string) ([]Remote, error) {
+	out, err := Out(ctx, "-C", path,
+		"config", "--get-regexp", `^remote\..*\.url$`)
+	if err != nil {
+		return nil, err
+	}
+
+	var remotes []Remote
+
+	for _, line := range strings.Split(out, "\n") {
+		key, url := line, ""
+		if n := strings.Index(line, " "); n >= 0 {
+			key, url = line[:n], line[n+1:]
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+		remotes = append(remotes, Remote{Name: name, URL: url})
+	}
+
+	return remotes, nil
+}
+
+// SetRemoteURL changes the URL of the named remote.
+func SetRemoteURL(ctx context.Context, path, name, url string) error {
+	return Run(ctx, "-C", path, "remote", "set-url", name, url)
+}
+
+// CreateBundle writes a bundle file with every ref of the repository.
+func CreateBundle(ctx context.Context, path, file string) error {
+	return Run(ctx, "-C", path, "bundle", "create", "--quiet", file, "--all")
+}
+
+// AddRemote adds a remote with the name and URL.
+func AddRemote(ctx context.Context, path, name, url string) error {
+	return Run(ctx, "-C", path, "remote", "add", name, url)
+}
+
+// Branch returns the short name of the checked out branch.  It fails when HEAD
+// is detached.
+func Branch(ctx context.Context, path string) (string, error) {
+	return Out(ctx, "-C", path, "symbolic-ref", "--quiet", "--short", "HEAD")
+}
+
+// LocalBranches returns the short names of the local branches.
+func LocalBranches(ctx context.Context, path string) ([]string, error) {
+	out, err := Out(ctx, "-C", path,
+		"for-each-ref", "--format=%(refname:short)", "refs/heads")
+	if err != nil || out == "" {
+		return nil, err
+	}
+
+	return strings.Split(out, "\n"), nil
+}
+
+// RefExists checks if the full ref name points to a commit.
+func RefExists(ctx context.Context, path, ref string) bool {
+	return bol(ctx, "-C", path, "rev-parse", "--verify", "--quiet", ref)
+}
+
+// Fsck checks the objects of the repository, failing when any are missing or
+// corrupt.
+func Fsck(ctx context.Context, path string) error {
+	return Run(ctx, "-C", path, "fsck", "--no-progress", "--no-dangling")
 }
 
 func Dirty(ctx context.Context, path string) bool {
@@ -95,23 +381,11 @@ func UpStatus(ctx context.Context, path string) (UpstreamStatus, error) {
 		return status, err
 	}
 
-	var seeking bool
-
-	for n := 0; n < len(output); n++ {
-		char := output[n]
-
-		if !seeking && char != '\n' {
-			continue
-		} else if !seeking && char == '\n' {
-			seeking = true
-			continue
-		}
-
-		if seeking && output[n] == '>' {
-			seeking = false
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, ">"):
 			status.Ahead++
-		} else if seeking && output[n] == '<' {
-			seeking = false
+		case strings.HasPrefix(line, "<"):
 			status.Behind++
 		}
 	}
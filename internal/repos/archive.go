@@ -0,0 +1,152 @@
+package repos
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+)
+
+// IsArchive checks if the path names a tarball, and if it is compressed with
+// gzip, by its extension: ".tar", ".tar.gz" or ".tgz".
+func IsArchive(path string) (archive, compressed bool) {
+	switch {
+	case strings.HasSuffix(path, ".tar"):
+		return true, false
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// WriteArchive writes the regular files under dir to a tarball, with paths
+// relative to dir.
+func WriteArchive(w io.Writer, dir string, compressed bool) error {
+	var gz *gzip.Writer
+
+	if compressed {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := filepath.Walk(dir, archiveFile(tw, dir)); err != nil {
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+
+	err := tw.Close()
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+
+	return nil
+}
+
+// archiveFile returns a walk function writing each regular file to the
+// tarball.
+func archiveFile(tw *tar.Writer, dir string) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = filepath.ToSlash(rel)
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+
+		return err
+	}
+}
+
+// ExtractArchive extracts the regular files of a tarball into dir.  Files that
+// would end up outside of dir are refused.
+func ExtractArchive(r io.Reader, dir string, compressed bool) error {
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("error reading archive: %w", err)
+		}
+
+		defer gz.Close()
+
+		r = gz
+	}
+
+	var (
+		tr   = tar.NewReader(r)
+		root = filepath.Clean(dir) + string(os.PathSeparator)
+	)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading archive: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(path, root) {
+			return fmt.Errorf("%w: %s", errs.ErrArchivePath, hdr.Name)
+		}
+
+		if err := extractFile(tr, path); err != nil {
+			return fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,251 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/git"
+)
+
+// ActionBundle means a bundle of the repository was written.
+const ActionBundle Action = "bundle"
+
+const (
+	reasonBundle = "from bundle"
+	reasonShared = "bundle shared with another repository"
+)
+
+// BundlePath is where the bundle of the repo is kept in the dir directory.  It
+// is made of the host and path of the URL like MirrorPath, ending with
+// ".bundle" instead.
+func BundlePath(dir string, r Repo) string {
+	return strings.TrimSuffix(MirrorPath(dir, r.URL), ".git") + ".bundle"
+}
+
+// CreateBundles writes a bundle of every local repo at its BundlePath in dir,
+// with all of its branches and tags.  Syncing with SyncOptions.Bundles set to
+// the same dir clones or fetches the repos from the bundles, such as on a
+// machine without network access.
+//
+// Takes in a result channel which is sent the result of every repository as
+// soon as it is bundled.  Repos with the same URL as an earlier one share its
+// bundle and are skipped.  The channel is closed at the end of bundling.
+// errs.ErrOccurred is returned when any repository failed to bundle.
+func CreateBundles(
+	ctx context.Context,
+	repos []Repo,
+	dir string,
+	jobs int,
+	resCh chan Result,
+) error {
+	if resCh == nil {
+		return errs.ErrNilChan
+	}
+
+	defer close(resCh)
+
+	var (
+		mu          sync.Mutex
+		errOccurred bool
+		first       = make(map[string]int, len(repos))
+	)
+
+	for i := len(repos) - 1; i >= 0; i-- {
+		first[BundlePath(dir, repos[i])] = i
+	}
+
+	forEach(ctx, repos, jobs, func(i int, r Repo) {
+		start := time.Now()
+		file := BundlePath(dir, r)
+
+		if first[file] != i {
+			resCh <- Result{Repo: r, Action: ActionSkip, Reason: reasonShared}
+
+			return
+		}
+
+		res := Result{Repo: r, Action: ActionBundle}
+
+		res.Err = createBundle(ctx, r, file)
+		res.OldHead, _ = git.Head(ctx, r.Path)
+		res.NewHead = res.OldHead
+		res.Duration = time.Since(start)
+
+		if res.Err != nil {
+			mu.Lock()
+			errOccurred = true
+			mu.Unlock()
+		}
+
+		resCh <- res
+	})
+
+	if err := doneErr(ctx); err != nil {
+		return err
+	}
+
+	if errOccurred {
+		return errs.ErrOccurred
+	}
+
+	return nil
+}
+
+func createBundle(ctx context.Context, r Repo, file string) error {
+	if _, err := os.Stat(r.Path); err != nil {
+		return errs.ErrNotCloned
+	}
+
+	// Git writes the bundle relative to the repository otherwise.
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	return git.CreateBundle(ctx, r.Path, file)
+}
+
+// bundleFile returns the absolute path of the bundle of the repo.
+func bundleFile(dir string, r Repo) (string, error) {
+	file, err := filepath.Abs(BundlePath(dir, r))
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		return "", fmt.Errorf("%w: %s", errs.ErrNoBundle, file)
+	}
+
+	return file, nil
+}
+
+// bundleAction clones or fetches the repo from its bundle in dir, and then
+// sets its remotes to the configured URLs.
+func bundleAction(
+	ctx context.Context,
+	r Repo,
+	dir string,
+	switchBranch bool,
+) (Action, string, error) {
+	file, err := bundleFile(dir, r)
+	if err != nil {
+		return ActionFetch, "", err
+	}
+
+	if _, err := os.Stat(r.Path); err != nil {
+		if r.Kind == KindWorktree {
+			return ActionClone, reasonNotCloned, errs.ErrWorktreeMissing
+		}
+
+		return ActionClone, reasonBundle, cloneBundle(ctx, r, file)
+	}
+
+	refspec := "+refs/heads/*:refs/remotes/" + r.RemoteName() + "/*"
+	if r.Kind == KindBare {
+		refspec = bareRefspec
+	}
+
+	err = git.Fetch(ctx, r.Path, file, refspec, "refs/tags/*:refs/tags/*")
+	if err != nil {
+		return ActionFetch, "", err
+	}
+
+	if err := setRemoteURLs(ctx, r); err != nil {
+		return ActionFetch, "", err
+	}
+
+	if r.Kind == KindBare {
+		return ActionFetch, reasonBundle, nil
+	}
+
+	return updateCheckout(ctx, r, switchBranch)
+}
+
+// cloneBundle clones the repo from the bundle file.  The depth and filter
+// options are left out since a bundle is a local file.
+func cloneBundle(ctx context.Context, r Repo, file string) error {
+	opts := r.Options
+	opts.Depth, opts.Filter = 0, ""
+
+	var args []string
+
+	if r.Kind == KindBare {
+		opts.Sparse = nil
+		args = append(args, "--bare")
+	}
+
+	return cloneAtomic(r.Path, func(tmp string) error {
+		r.Path = tmp
+
+		err := git.Clone(ctx, file, tmp, append(args, opts.cloneArgs()...)...)
+		if err != nil {
+			return err
+		}
+
+		if len(opts.Sparse) > 0 {
+			err := git.SparseCheckout(ctx, tmp, opts.Sparse...)
+			if err != nil {
+				return err
+			}
+		}
+
+		return setRemoteURLs(ctx, r)
+	})
+}
+
+// setRemoteURLs sets every remote of the repo to its configured URL, adding
+// the ones that are missing.  Nothing is fetched.
+func setRemoteURLs(ctx context.Context, r Repo) error {
+	remotes := append([]Remote{{Name: r.RemoteName(), URL: r.URL}},
+		r.Remotes...)
+
+	for _, remote := range remotes {
+		url, err := git.RemoteURL(ctx, r.Path, remote.Name)
+
+		switch {
+		case err != nil:
+			err = git.AddRemote(ctx, r.Path, remote.Name, remote.URL)
+		case url != remote.URL:
+			err = git.SetRemoteURL(ctx, r.Path, remote.Name, remote.URL)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planBundle decides what bundleAction would do without changing anything.
+func planBundle(
+	ctx context.Context,
+	r Repo,
+	dir string,
+) (Action, string, error) {
+	if _, err := bundleFile(dir, r); err != nil {
+		return ActionFetch, "", err
+	}
+
+	if _, err := os.Stat(r.Path); err != nil {
+		return ActionClone, reasonBundle, nil
+	}
+
+	if r.Kind != KindBare {
+		if reason := worktreeChanges(ctx, r); reason != "" {
+			return ActionFetch, reason, nil
+		}
+	}
+
+	return ActionFetch, reasonBundle, nil
+}
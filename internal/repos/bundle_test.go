@@ -0,0 +1,135 @@
+package repos_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/git"
+	. "gitlab.com/kibafox/repos/internal/repos"
+)
+
+var _ = Describe("Bundle", func() {
+	It("clones and fetches from bundles and resets the remotes", func() {
+		repos, dir := syncSetupRepos()
+		defer cleanRepos(dir)
+
+		ctx := context.Background()
+		bundles := path.Join(dir, "bundles")
+
+		syncSimple(repos)
+		bundleSimple(repos, bundles)
+
+		copies := make([]Repo, len(repos))
+		for i, r := range repos {
+			copies[i] = Repo{Path: path.Join(dir, "copy", r.Path), URL: r.URL}
+			Expect(BundlePath(bundles, r)).Should(BeARegularFile())
+		}
+
+		By("Cloning from the bundles")
+		opts := SyncOptions{Bundles: bundles}
+		for _, res := range syncSimpleOpts(copies, opts) {
+			Expect(res.Action).Should(Equal(ActionClone))
+
+			url, err := git.Out(ctx, "-C", res.Repo.Path,
+				"remote", "get-url", "origin")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(url).Should(Equal(res.Repo.URL))
+		}
+
+		for i := range repos {
+			Expect(repoHeadHash(copies[i].Path)).
+				Should(Equal(repoHeadHash(repos[i].Path)))
+		}
+
+		By("Fetching new commits from updated bundles")
+		makeCommit(repos[0].URL, "NEW.md", "new", "Add NEW.md")
+		syncSimple(repos)
+		bundleSimple(repos, bundles)
+
+		results := syncSimpleOpts(copies[:1], opts)
+		Expect(results[0].Updated()).Should(BeTrue())
+		Expect(repoHeadHash(copies[0].Path)).
+			Should(Equal(repoHeadHash(repos[0].URL)))
+	})
+
+	It("writes and extracts archives of bundles", func() {
+		_, dir := syncSetupRepos()
+		defer cleanRepos(dir)
+
+		for _, compressed := range []bool{false, true} {
+			var buf bytes.Buffer
+
+			Expect(WriteArchive(&buf, dir, compressed)).To(Succeed())
+
+			out, err := ioutil.TempDir(dir, "extract")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(ExtractArchive(&buf, out, compressed)).To(Succeed())
+
+			content, err := ioutil.ReadFile(
+				path.Join(out, "remote", "kiba", "README.md"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).Should(Equal("# README\n\nTODO\n"))
+		}
+	})
+
+	It("refuses to extract files outside of the directory", func() {
+		_, dir := syncSetupRepos()
+		defer cleanRepos(dir)
+
+		var buf bytes.Buffer
+
+		tw := tar.NewWriter(&buf)
+		Expect(tw.WriteHeader(&tar.Header{
+			Name:     "../../escaped.bundle",
+			Mode:     0600,
+			Size:     1,
+			Typeflag: tar.TypeReg,
+		})).To(Succeed())
+		_, err := tw.Write([]byte{'x'})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tw.Close()).To(Succeed())
+
+		err = ExtractArchive(&buf, path.Join(dir, "out"), false)
+		Expect(errors.Is(err, errs.ErrArchivePath)).Should(BeTrue())
+		Expect(path.Join(dir, "..", "escaped.bundle")).
+			ShouldNot(BeAnExistingFile())
+	})
+})
+
+// bundleSimple will write a bundle of every repo to dir, expecting each to
+// succeed.
+func bundleSimple(repos []Repo, dir string) {
+	var (
+		err         error
+		resCh       = make(chan Result, 1)
+		done        = make(chan struct{})
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	)
+
+	defer cancel()
+
+	go func() {
+		defer close(done)
+
+		err = CreateBundles(ctx, repos, dir, 0, resCh)
+	}()
+
+	for res := range resCh {
+		Expect(res.Err).ShouldNot(HaveOccurred())
+		Expect(res.Action).Should(Equal(ActionBundle))
+	}
+
+	<-done
+
+	Expect(err).ShouldNot(HaveOccurred())
+}
@@ -0,0 +1,184 @@
+package repos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+)
+
+// ExecOptions changes how Exec runs the command.
+type ExecOptions struct {
+	// Jobs is the maximum number of repositories the command runs in at the
+	// same time.  A value less than 1 runs in one repository at a time.
+	Jobs int
+
+	// Stdout and Stderr receive the output of the command.  Every line is
+	// prefixed with the path of the repository it came from.  Output is
+	// discarded when nil.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ExecResult is the outcome of running a command in a repository.
+type ExecResult struct {
+	Repo Repo
+	// Code is the exit code of the command.  It is -1 when the command could
+	// not be run or did not exit on its own.
+	Code int
+	// Err is set when the command could not be run or exited with a non-zero
+	// code.
+	Err error
+}
+
+// Exec runs the command given by args inside the local path of each repo.  The
+// results are returned in the same order as repos.  Once ctx is done, no
+// command is started in the remaining repos, and they have no result.
+func Exec(
+	ctx context.Context,
+	repos []Repo,
+	args []string,
+	opts ExecOptions,
+) ([]ExecResult, error) {
+	if len(args) == 0 {
+		return nil, errs.ErrNoCommand
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errs.ErrHomeNotFound(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		results   = make([]ExecResult, len(repos))
+		attempted = make([]bool, len(repos))
+	)
+
+	forEach(ctx, repos, opts.Jobs, func(i int, r Repo) {
+		prefix := ContractHome(home, r.Path) + ": "
+		stdout := &lineWriter{mu: &mu, w: opts.Stdout, prefix: prefix}
+		stderr := &lineWriter{mu: &mu, w: opts.Stderr, prefix: prefix}
+
+		results[i] = execRepo(ctx, r, args, stdout, stderr)
+		attempted[i] = true
+
+		stdout.Flush()
+		stderr.Flush()
+	})
+
+	// The repos not started before ctx was done are left out.
+	n := 0
+
+	for i, res := range results {
+		if attempted[i] {
+			results[n] = res
+			n++
+		}
+	}
+
+	results = results[:n]
+
+	if err := doneErr(ctx); err != nil {
+		return results, err
+	}
+
+	for _, res := range results {
+		if res.Err != nil {
+			return results, errs.ErrOccurred
+		}
+	}
+
+	return results, nil
+}
+
+func execRepo(
+	ctx context.Context,
+	r Repo,
+	args []string,
+	stdout, stderr io.Writer,
+) ExecResult {
+	res := ExecResult{Repo: r, Code: -1}
+
+	if _, err := os.Stat(r.Path); err != nil {
+		res.Err = fmt.Errorf("error running in %s: %w", r.Path, err)
+
+		return res
+	}
+
+	// nolint: gosec // running the user's command is the point
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = r.Path
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case err == nil:
+		res.Code = 0
+	case errors.As(err, &exitErr):
+		res.Code = exitErr.ExitCode()
+		res.Err = fmt.Errorf("%s exited in %s: %w", args[0], r.Path, err)
+	default:
+		res.Err = fmt.Errorf("error running in %s: %w", r.Path, err)
+	}
+
+	return res
+}
+
+// lineWriter writes whole lines to w, each starting with prefix.  The lock is
+// shared between writers so lines from different commands do not interleave.
+type lineWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+
+	for {
+		n := bytes.IndexByte(lw.buf, '\n')
+		if n < 0 {
+			break
+		}
+
+		lw.writeLine(lw.buf[:n+1])
+		lw.buf = lw.buf[n+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes out a final line that did not end with a newline.
+func (lw *lineWriter) Flush() {
+	if len(lw.buf) == 0 {
+		return
+	}
+
+	lw.writeLine(append(lw.buf, '\n'))
+	lw.buf = nil
+}
+
+func (lw *lineWriter) writeLine(line []byte) {
+	if lw.w == nil {
+		return
+	}
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	// Errors are ignored so a broken output does not stop the command.
+	_, _ = io.WriteString(lw.w, lw.prefix)
+	_, _ = lw.w.Write(line)
+}
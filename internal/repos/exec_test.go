@@ -0,0 +1,87 @@
+package repos_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	. "gitlab.com/kibafox/repos/internal/repos"
+)
+
+var _ = Describe("Exec", func() {
+	var (
+		repos []Repo
+		dir   string
+	)
+
+	BeforeEach(func() {
+		repos, dir = syncSetupRepos()
+		syncSimple(repos)
+	})
+
+	AfterEach(func() {
+		cleanRepos(dir)
+	})
+
+	It("runs the command in every repository", func() {
+		var stdout, stderr bytes.Buffer
+
+		results, err := Exec(context.Background(), repos,
+			[]string{"sh", "-c", "ls; echo oops >&2"},
+			ExecOptions{Jobs: 2, Stdout: &stdout, Stderr: &stderr})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(results).Should(Equal([]ExecResult{
+			{Repo: repos[0], Code: 0},
+			{Repo: repos[1], Code: 0},
+		}))
+
+		Expect(stdout.String()).Should(ContainSubstring(
+			repos[0].Path + ": README.md\n"))
+		Expect(stdout.String()).Should(ContainSubstring(
+			repos[1].Path + ": README.md\n"))
+		Expect(stderr.String()).Should(ContainSubstring(
+			repos[0].Path + ": oops\n"))
+		Expect(stderr.String()).Should(ContainSubstring(
+			repos[1].Path + ": oops\n"))
+	})
+
+	It("reports exit codes and repositories that do not exist", func() {
+		Expect(os.RemoveAll(repos[1].Path)).To(Succeed())
+
+		results, err := Exec(context.Background(), repos,
+			[]string{"sh", "-c", "printf partial; exit 3"}, ExecOptions{})
+		Expect(err).Should(Equal(errs.ErrOccurred))
+
+		Expect(results).Should(HaveLen(2))
+		Expect(results[0].Code).Should(Equal(3))
+		Expect(results[0].Err).Should(HaveOccurred())
+		Expect(results[1].Code).Should(Equal(-1))
+		Expect(results[1].Err).Should(HaveOccurred())
+	})
+
+	It("leaves out the repositories not started once stopped", func() {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			200*time.Millisecond)
+		defer cancel()
+
+		results, err := Exec(ctx, repos, []string{"sleep", "2"},
+			ExecOptions{Jobs: 1})
+		Expect(errors.Is(err, errs.ErrContextTimeout)).Should(BeTrue())
+
+		Expect(results).Should(HaveLen(1))
+		Expect(results[0].Repo).Should(Equal(repos[0]))
+		Expect(results[0].Err).Should(HaveOccurred())
+	})
+
+	It("requires a command", func() {
+		_, err := Exec(context.Background(), repos, nil, ExecOptions{})
+		Expect(err).Should(Equal(errs.ErrNoCommand))
+	})
+})
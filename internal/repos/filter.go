@@ -0,0 +1,94 @@
+package repos
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Filter selects repositories by their tags and paths.  The zero value selects
+// every repository.
+type Filter struct {
+	// Tags selects repositories with at least one of the tags.
+	Tags []string
+	// ExcludeTags drops repositories with any of the tags.
+	ExcludeTags []string
+	// Paths selects repositories where the path, or one of its parent
+	// directories, matches at least one of the glob patterns.  The pattern
+	// syntax is the same as filepath.Match.
+	Paths []string
+}
+
+// Match checks if the repo is selected by the filter.  An error is only
+// returned for malformed path patterns.
+func (f Filter) Match(r Repo) (bool, error) {
+	if len(f.Tags) > 0 && !hasAnyTag(r, f.Tags) {
+		return false, nil
+	}
+
+	if hasAnyTag(r, f.ExcludeTags) {
+		return false, nil
+	}
+
+	if len(f.Paths) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range f.Paths {
+		ok, err := matchPath(pattern, r.Path)
+		if err != nil {
+			return false, fmt.Errorf("error matching path %s: %w", pattern, err)
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Apply returns the repos selected by the filter, keeping their order.
+func (f Filter) Apply(repos []Repo) ([]Repo, error) {
+	selected := make([]Repo, 0, len(repos))
+
+	for _, r := range repos {
+		ok, err := f.Match(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			selected = append(selected, r)
+		}
+	}
+
+	return selected, nil
+}
+
+func hasAnyTag(r Repo, tags []string) bool {
+	for _, want := range tags {
+		for _, tag := range r.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchPath matches the pattern against the path and each of its parents.
+func matchPath(pattern, path string) (bool, error) {
+	pattern = filepath.Clean(pattern)
+
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		ok, err := filepath.Match(pattern, p)
+		if err != nil || ok {
+			return ok, err
+		}
+
+		if p == filepath.Dir(p) {
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package repos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "gitlab.com/kibafox/repos/internal/repos"
+)
+
+var _ = Describe("Filter", func() {
+	repos := []Repo{
+		{Path: "/home/user/work/api", Tags: []string{"work"}},
+		{Path: "/home/user/work/web", Tags: []string{"work", "oss"}},
+		{Path: "/home/user/oss/repos", Tags: []string{"oss"}},
+		{Path: "/home/user/notes"},
+	}
+
+	apply := func(f Filter) []Repo {
+		selected, err := f.Apply(repos)
+		Expect(err).ToNot(HaveOccurred())
+
+		return selected
+	}
+
+	It("selects everything when empty", func() {
+		Expect(apply(Filter{})).Should(Equal(repos))
+	})
+
+	It("selects repos with any of the tags", func() {
+		Expect(apply(Filter{Tags: []string{"oss"}})).Should(Equal(
+			[]Repo{repos[1], repos[2]}))
+	})
+
+	It("drops repos with any of the excluded tags", func() {
+		Expect(apply(Filter{
+			Tags:        []string{"work"},
+			ExcludeTags: []string{"oss"},
+		})).Should(Equal([]Repo{repos[0]}))
+
+		Expect(apply(Filter{ExcludeTags: []string{"work"}})).Should(Equal(
+			[]Repo{repos[2], repos[3]}))
+	})
+
+	It("selects repos where the path or a parent matches a glob", func() {
+		Expect(apply(Filter{Paths: []string{"/home/user/work"}})).Should(
+			Equal([]Repo{repos[0], repos[1]}))
+
+		Expect(apply(Filter{Paths: []string{"/home/*/*s"}})).Should(
+			Equal([]Repo{repos[2], repos[3]}))
+	})
+
+	It("fails on malformed globs", func() {
+		_, err := Filter{Paths: []string{"[oops"}}.Apply(repos)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -2,22 +2,37 @@ package repos
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"gitlab.com/kibafox/repos/internal/errs"
 	"gitlab.com/kibafox/repos/internal/git"
 )
 
 // FromPath will search a path for git repositories.  It builds a slice of repos
-// from the paths and using the first URL for the `origin` remote configured.
+// from the paths and using the URL of the `origin` remote, or the first remote
+// when there is no origin.  Every other remote is kept in Remotes.  The branch
+// checked out is kept in the branch option.
+// The repos are sorted by path.
+//
+// Besides regular repositories with a ".git" directory, bare repositories,
+// linked worktrees and submodules are found and their Kind is set.  Worktrees
+// and submodules have a ".git" file pointing to the git directory instead.
+//
+// Directories are read concurrently, and remotes are looked up concurrently
+// while the search goes on.  Each is limited to opts.Jobs at the same time, or
+// to one when it is less.
+//
+// Paths matching the exclude patterns of opts, or of an IgnoreFile in a
+// directory above them, are skipped along with everything below them.
 func FromPath(
 	ctx context.Context,
 	path string,
+	opts ImportOptions,
 	errCh chan error,
 ) ([]Repo, error) {
 	if errCh == nil {
@@ -31,55 +46,141 @@ func FromPath(
 		return nil, errs.ErrHomeNotFound(err)
 	}
 
+	// Searching has nothing to finish, so it ends as soon as it is stopped.
+	ctx, cancel := cancelOnStop(ctx)
+	defer cancel()
+
 	exPath := ExpandHome(home, path)
-	repos := make([]Repo, 0, 9)
-
-	var errOccurred bool
-
-	if err := filepath.Walk(exPath,
-		func(p string, info os.FileInfo, e error) error {
-			if ctx.Err() != nil {
-				switch {
-				case errors.Is(ctx.Err(), context.Canceled):
-					return errs.ErrContextCanceled
-				case errors.Is(ctx.Err(), context.DeadlineExceeded):
-					return errs.ErrContextTimeout
-				default:
-					return fmt.Errorf("context error: %w", ctx.Err())
-				}
-			}
 
-			if e != nil {
-				errOccurred = true
-				errCh <- fmt.Errorf("error visiting path: %s: %w", p, e)
-				return filepath.SkipDir
-			}
+	ex, err := newExcludes(exPath, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Jobs < 1 {
+		opts.Jobs = 1
+	}
+
+	var (
+		found = make(chan Repo, opts.Jobs)
+		w     = newWalker(ctx, opts.MaxDepth, errCh, found)
+		repos = lookupRemotes(ctx, found, opts.Jobs)
+	)
+
+	err = w.walk(exPath, opts.Jobs, []excludes{ex})
+
+	close(found)
+
+	r := <-repos
+	if err != nil {
+		return r, fmt.Errorf("error walking path %s: %w", path, err)
+	}
+
+	if w.errOccurred {
+		return r, errs.ErrOccurred
+	}
+
+	return r, nil
+}
+
+// lookupRemotes looks up the remotes of each repository received, using at
+// most jobs concurrent workers.  Once the found channel is closed, the
+// repos are sent sorted by path.
+func lookupRemotes(
+	ctx context.Context,
+	found chan Repo,
+	jobs int,
+) chan []Repo {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var (
+		out   = make(chan []Repo, 1)
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		repos = make([]Repo, 0, 9)
+	)
 
-			if info.IsDir() && info.Name() == ".git" {
-				r := filepath.Dir(p)
+	wg.Add(jobs)
 
-				// Ignore errors here.  An empty string means we could not find
-				// the remote origin.
-				remote, _ := git.Origin(ctx, r)
+	for n := 0; n < jobs; n++ {
+		go func() {
+			defer wg.Done()
 
-				repos = append(repos, Repo{Path: r, URL: remote})
+			for r := range found {
+				// Ignore errors here.  No remotes means the URL is left
+				// empty.
+				remotes, _ := git.Remotes(ctx, r.Path)
+				setRemotes(&r, remotes)
 
-				return filepath.SkipDir
+				r.Options.Branch = trackedBranch(ctx, r.Path)
+
+				mu.Lock()
+				repos = append(repos, r)
+				mu.Unlock()
 			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+
+		sort.Slice(repos, func(i, j int) bool {
+			return repos[i].Path < repos[j].Path
+		})
+
+		out <- repos
+	}()
+
+	return out
+}
 
-			return nil
-		}); err != nil {
-		return repos, fmt.Errorf("error walking path %s: %w", path, err)
+// trackedBranch returns the checked out branch of the repository.  It is empty
+// when HEAD is detached, or when the branch has no commits yet, since such a
+// branch cannot be cloned.
+func trackedBranch(ctx context.Context, path string) string {
+	if _, err := git.Head(ctx, path); err != nil {
+		return ""
 	}
 
-	if errOccurred {
-		return repos, errs.ErrOccurred
+	branch, _ := git.Branch(ctx, path)
+
+	return branch
+}
+
+// setRemotes sets the URL of the repo to the "origin" remote, or the first
+// remote when there is no origin, and keeps the rest as its other remotes.
+func setRemotes(r *Repo, remotes []git.Remote) {
+	main := -1
+
+	for i, remote := range remotes {
+		if remote.Name == DefaultRemote {
+			main = i
+
+			break
+		}
+	}
+
+	if main < 0 && len(remotes) > 0 {
+		main = 0
+		r.Options.Remote = remotes[0].Name
 	}
 
-	return repos, nil
+	for i, remote := range remotes {
+		if i == main {
+			r.URL = remote.URL
+
+			continue
+		}
+
+		r.Remotes = append(r.Remotes,
+			Remote{Name: remote.Name, URL: remote.URL})
+	}
 }
 
-// WriteRepos writes the given repos in a format compatible with the parser.
+// WriteRepos writes the given repos in a format compatible with the parser.  A
+// section header is written whenever the tags change from one repo to the next.
 func WriteRepos(repos []Repo, writer io.Writer) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -93,19 +194,32 @@ func WriteRepos(repos []Repo, writer io.Writer) error {
 		}
 	}
 
+	var tags []string
+
 	for _, repo := range repos {
 		pad := max - len(repo.Path) + 1
 
 		var str string
-		if repo.URL == "" {
-			str = fmt.Sprintf(
-				"# Could not find remote origin for local repository: %s\n",
+		if !sameStrings(tags, repo.Tags) {
+			tags = repo.Tags
+			str = "[" + strings.Join(tags, " ") + "]\n"
+		}
+
+		switch {
+		case repo.Kind == KindSubmodule:
+			str += fmt.Sprintf(
+				"# Submodule managed by its parent repository: %s\n",
+				ContractHome(home, repo.Path))
+		case repo.URL == "":
+			str += fmt.Sprintf(
+				"# Could not find a remote for local repository: %s\n",
 				ContractHome(home, repo.Path))
-		} else {
-			str = fmt.Sprintf("%s%s%s\n",
+		default:
+			str += fmt.Sprintf("%s%s%s\n",
 				ContractHome(home, repo.Path),
 				strings.Repeat(" ", pad),
-				repo.URL)
+				strings.Join(append([]string{repo.URL},
+					repo.Fields()...), " "))
 		}
 
 		_, err := writer.Write([]byte(str))
@@ -116,3 +230,27 @@ func WriteRepos(repos []Repo, writer io.Writer) error {
 
 	return nil
 }
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
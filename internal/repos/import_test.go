@@ -4,16 +4,19 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"gitlab.com/kibafox/repos/internal/errs"
 	"gitlab.com/kibafox/repos/internal/git"
 	. "gitlab.com/kibafox/repos/internal/repos"
 )
@@ -49,6 +52,228 @@ var _ = Describe("Import", func() {
 		))
 	})
 
+	It("collects every remote of a repository", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		ctx := context.Background()
+		klok := path.Join(dir, "git.fqdn", "kira", "klok")
+		spike := path.Join(dir, "git.fqdn", "kiba", "spike")
+
+		Expect(git.Run(ctx, "-C", klok, "remote", "add", "upstream",
+			"git@github.com/KibaFox/klok")).To(Succeed())
+		Expect(git.Run(ctx, "-C", spike, "remote", "add", "upstream",
+			"git@github.com/KibaFox/spike")).To(Succeed())
+
+		repos := make(map[string]Repo)
+		for _, r := range fromPathSimple(dir) {
+			repos[r.Path] = r
+		}
+
+		Expect(repos[klok]).Should(Equal(Repo{
+			Path:    klok,
+			URL:     "git@github.com/KiraFox/klok",
+			Remotes: []Remote{{"upstream", "git@github.com/KibaFox/klok"}},
+		}))
+		Expect(repos[spike]).Should(Equal(Repo{
+			Path:    spike,
+			URL:     "git@github.com/KibaFox/spike",
+			Options: Options{Remote: "upstream"},
+		}))
+	})
+
+	It("keeps the branch checked out", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		ctx := context.Background()
+		klok := path.Join(dir, "git.fqdn", "kira", "klok")
+		makeCommit(klok, "README", "klok", "Initial commit")
+		Expect(git.Run(ctx, "-C", klok,
+			"checkout", "--quiet", "-b", "release")).To(Succeed())
+
+		for _, r := range fromPathSimple(dir) {
+			if r.Path == klok {
+				Expect(r.Options.Branch).Should(Equal("release"))
+			} else {
+				// There are no commits to clone the branch from.
+				Expect(r.Options.Branch).Should(BeEmpty())
+			}
+		}
+	})
+
+	It("skips paths matching exclude patterns", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		repos := fromPathOpts(dir, ImportOptions{
+			Exclude: []string{"dotfiles", "git.fqdn/kiba/t*"},
+		})
+
+		Expect(repos).Should(ConsistOf(
+			Repo{
+				Path: path.Join(dir, "git.fqdn", "kira", "klok"),
+				URL:  "git@github.com/KiraFox/klok",
+			},
+			Repo{
+				Path: path.Join(dir, "git.fqdn", "kiba", "spike"),
+				URL:  "",
+			},
+		))
+	})
+
+	It("skips paths listed in an ignore file", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		Expect(ioutil.WriteFile(path.Join(dir, "git.fqdn", "kiba", IgnoreFile),
+			[]byte("# scratch repos\n\ntest\nspike/\n"), 0644)).To(Succeed())
+
+		repos := fromPathSimple(dir)
+
+		Expect(repos).Should(ConsistOf(
+			Repo{
+				Path: path.Join(dir, "git.fqdn", "kiba", "dotfiles"),
+				URL:  "git@gitlab.com/KibaFox/dotfiles",
+			},
+			Repo{
+				Path: path.Join(dir, "git.fqdn", "kira", "dotfiles"),
+				URL:  "git@github.com/KiraFox/dotfiles",
+			},
+			Repo{
+				Path: path.Join(dir, "git.fqdn", "kira", "klok"),
+				URL:  "git@github.com/KiraFox/klok",
+			},
+		))
+	})
+
+	It("does not search deeper than the max depth", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		Expect(fromPathOpts(dir, ImportOptions{MaxDepth: 2})).Should(BeEmpty())
+		Expect(fromPathOpts(dir, ImportOptions{MaxDepth: 3})).Should(HaveLen(5))
+	})
+
+	It("rejects malformed exclude patterns", func() {
+		_, err := FromPath(context.Background(), ".",
+			ImportOptions{Exclude: []string{"["}}, make(chan error, 1))
+		Expect(errors.Is(err, errs.ErrExcludePattern)).To(BeTrue())
+	})
+
+	It("finds the same repos sorted by path with any number of jobs", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		ctx := context.Background()
+
+		for i := 0; i < 12; i++ {
+			repo := path.Join(dir, "many", fmt.Sprint(i%3), fmt.Sprint(i))
+			Expect(git.Run(ctx, "init", "--quiet", repo)).To(Succeed())
+		}
+
+		want := fromPathOpts(dir, ImportOptions{Jobs: 1})
+		Expect(want).Should(HaveLen(17))
+
+		for _, jobs := range []int{-1, 0, 2, 4, 16} {
+			repos := fromPathOpts(dir, ImportOptions{Jobs: jobs})
+
+			Expect(sort.SliceIsSorted(repos, func(i, j int) bool {
+				return repos[i].Path < repos[j].Path
+			})).Should(BeTrue())
+			Expect(repos).Should(Equal(want))
+		}
+	})
+
+	It("reports errors while walking without stopping the walk", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		By("Writing a .git file without a git directory")
+		broken := path.Join(dir, "broken")
+		Expect(os.MkdirAll(broken, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path.Join(broken, ".git"),
+			[]byte("nonsense\n"), 0600)).To(Succeed())
+
+		By("Writing an ignore file with a malformed pattern")
+		Expect(ioutil.WriteFile(path.Join(dir, "git.fqdn", IgnoreFile),
+			[]byte("[\n"), 0600)).To(Succeed())
+
+		for _, jobs := range []int{1, 4} {
+			repos, walkErrs, err := fromPathResult(context.Background(), dir,
+				ImportOptions{Jobs: jobs})
+
+			Expect(err).Should(Equal(errs.ErrOccurred))
+			Expect(repos).Should(HaveLen(5))
+			Expect(walkErrs).Should(HaveLen(2))
+
+			Expect(errors.Is(walkErrs[0], errs.ErrGitFile) ||
+				errors.Is(walkErrs[1], errs.ErrGitFile)).Should(BeTrue())
+			Expect(errors.Is(walkErrs[0], errs.ErrExcludePattern) ||
+				errors.Is(walkErrs[1], errs.ErrExcludePattern)).
+				Should(BeTrue())
+		}
+	})
+
+	It("ends the walk once canceled or stopped", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		stop := make(chan struct{})
+		close(stop)
+
+		stopped := WithStop(context.Background(), stop)
+
+		for _, jobs := range []int{1, 4} {
+			opts := ImportOptions{Jobs: jobs}
+
+			_, _, err := fromPathResult(canceled, dir, opts)
+			Expect(errors.Is(err, errs.ErrContextCanceled)).Should(BeTrue())
+
+			_, _, err = fromPathResult(stopped, dir, opts)
+			Expect(errors.Is(err, errs.ErrInterrupted)).Should(BeTrue())
+		}
+	})
+
+	It("finds bare repos, linked worktrees and submodules", func() {
+		dir := importSetupRepos()
+		defer cleanRepos(dir)
+
+		ctx := context.Background()
+		main := path.Join(dir, "git.fqdn", "kira", "klok")
+		makeCommit(main, "README", "klok", "Initial commit")
+
+		bare := path.Join(dir, "bare", "klok.git")
+		Expect(git.Run(ctx, "clone", "--quiet", "--bare",
+			main, bare)).To(Succeed())
+
+		// Relative paths would be taken from inside the main repository.
+		wt := path.Join(dir, "worktrees", "klok")
+		absWT, err := filepath.Abs(wt)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(git.Run(ctx, "-C", main, "worktree", "add", "--quiet",
+			"--detach", absWT)).To(Succeed())
+
+		absBare, err := filepath.Abs(bare)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(git.Run(ctx, "-C", main, "-c", "protocol.file.allow=always",
+			"submodule", "--quiet", "add", absBare, "sub")).To(Succeed())
+
+		kinds := make(map[string]Kind)
+		for _, r := range fromPathSimple(dir) {
+			kinds[r.Path] = r.Kind
+		}
+
+		Expect(kinds).Should(HaveKeyWithValue(bare, KindBare))
+		Expect(kinds).Should(HaveKeyWithValue(wt, KindWorktree))
+		Expect(kinds).Should(HaveKeyWithValue(
+			path.Join(main, "sub"), KindSubmodule))
+		Expect(kinds).Should(HaveKeyWithValue(main, Kind("")))
+	})
+
 	It("writes repositories that can be parsed", func() {
 		h := home()
 
@@ -82,8 +307,8 @@ var _ = Describe("Import", func() {
 
 		Expect(buf.String()).Should(
 			Equal(`~/git.fqdn/kiba/dotfiles git@gitlab.com/KibaFox/dotfiles
-# Could not find remote origin for local repository: ~/git.fqdn/kiba/test
-# Could not find remote origin for local repository: ~/git.fqdn/kiba/spike
+# Could not find a remote for local repository: ~/git.fqdn/kiba/test
+# Could not find a remote for local repository: ~/git.fqdn/kiba/spike
 ~/git.fqdn/kira/dotfiles git@github.com/KiraFox/dotfiles
 ~/git.fqdn/kira/klok     git@github.com/KiraFox/klok
 `))
@@ -104,6 +329,89 @@ var _ = Describe("Import", func() {
 			},
 		))
 	})
+
+	It("writes the kind of repository", func() {
+		h := home()
+
+		data := []Repo{
+			{
+				Path: path.Join(h, "src", "bare.git"),
+				URL:  "git@gitlab.com/KibaFox/bare",
+				Kind: KindBare,
+			},
+			{
+				Path: path.Join(h, "src", "sub"),
+				URL:  "git@gitlab.com/KibaFox/sub",
+				Kind: KindSubmodule,
+			},
+		}
+
+		var buf bytes.Buffer
+		Expect(WriteRepos(data, &buf)).To(Succeed())
+
+		Expect(buf.String()).Should(Equal(
+			"~/src/bare.git git@gitlab.com/KibaFox/bare kind=bare\n" +
+				"# Submodule managed by its parent repository: ~/src/sub\n"))
+
+		Expect(parseSimple(&buf)).Should(Equal(data[:1]))
+	})
+
+	It("writes section headers for repository tags", func() {
+		h := home()
+
+		data := []Repo{
+			{
+				Path: path.Join(h, "kiba", "dotfiles"),
+				URL:  "git@gitlab.com/KibaFox/dotfiles",
+				Tags: []string{"personal"},
+			},
+			{
+				Path: path.Join(h, "kiba", "repos"),
+				URL:  "git@gitlab.com/KibaFox/repos",
+				Tags: []string{"personal"},
+			},
+			{
+				Path: path.Join(h, "kira", "klok"),
+				URL:  "git@github.com/KiraFox/klok",
+			},
+		}
+
+		var buf bytes.Buffer
+		Expect(WriteRepos(data, &buf)).To(Succeed())
+
+		Expect(buf.String()).Should(Equal(`[personal]
+~/kiba/dotfiles git@gitlab.com/KibaFox/dotfiles
+~/kiba/repos    git@gitlab.com/KibaFox/repos
+[]
+~/kira/klok     git@github.com/KiraFox/klok
+`))
+
+		Expect(parseSimple(bytes.NewReader(buf.Bytes()))).Should(Equal(data))
+	})
+
+	It("writes repository options that can be parsed", func() {
+		data := []Repo{
+			{
+				Path: path.Join(home(), "git.fqdn", "kiba", "dotfiles"),
+				URL:  "git@gitlab.com/KibaFox/dotfiles",
+				Options: Options{
+					Branch: "release",
+					Depth:  1,
+					Remote: "upstream",
+					NoTags: true,
+				},
+			},
+		}
+
+		var buf bytes.Buffer
+		Expect(WriteRepos(data, &buf)).To(Succeed())
+
+		Expect(buf.String()).Should(Equal(
+			"~/git.fqdn/kiba/dotfiles git@gitlab.com/KibaFox/dotfiles " +
+				"branch=release depth=1 remote=upstream tags=false\n"))
+
+		Expect(parseSimple(bytes.NewReader(buf.Bytes()))).Should(Equal(data))
+	})
 })
 
 type testrepo struct {
@@ -158,6 +466,33 @@ func importSetupRepos() (dir string) {
 	return dir
 }
 
+// fromPathResult imports with the options, collecting the errors sent while
+// walking.  It expects the import to end in time, whether it failed or not.
+func fromPathResult(
+	ctx context.Context,
+	path string,
+	opts ImportOptions,
+) (repos []Repo, walkErrs []error, err error) {
+	var (
+		errCh = make(chan error)
+		done  = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		repos, err = FromPath(ctx, path, opts, errCh)
+	}()
+
+	for e := range errCh {
+		walkErrs = append(walkErrs, e)
+	}
+
+	Eventually(done, 3*time.Second).Should(BeClosed())
+
+	return repos, walkErrs, err
+}
+
 func cleanRepos(dir string) {
 	Expect(os.RemoveAll(dir)).To(Succeed())
 }
@@ -171,26 +506,17 @@ func home() string {
 
 // fromPathSimple will do a simple import; expects it to complete successfully.
 func fromPathSimple(path string) []Repo {
-	var (
-		repos       []Repo
-		err         error
-		errs        = make(chan error, 1)
-		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
-	)
+	return fromPathOpts(path, ImportOptions{})
+}
 
+// fromPathOpts will import with the options; expects it to complete
+// successfully.
+func fromPathOpts(path string, opts ImportOptions) []Repo {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	go func() {
-		for err := range errs {
-			log.Println(fmt.Errorf("import: %w", err))
-		}
-	}()
-
-	go func() {
-		repos, err = FromPath(ctx, path, errs)
-	}()
-
-	Consistently(errs).ShouldNot(Receive())
+	repos, walkErrs, err := fromPathResult(ctx, path, opts)
+	Expect(walkErrs).Should(BeEmpty())
 	Expect(err).ShouldNot(HaveOccurred())
 	Expect(ctx.Err()).ToNot(HaveOccurred())
 
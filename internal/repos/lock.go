@@ -0,0 +1,138 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/git"
+)
+
+// ActionCheckout means the locked commit, or the configured branch, was
+// checked out.
+const ActionCheckout Action = "checkout"
+
+// Lock records the exact state of each local repository.  It returns a copy of
+// the repos with the branch and commit options set to what is checked out.
+// The branch is left empty when HEAD is detached.  Syncing the returned repos
+// with SyncOptions.Locked restores them to the same commits.
+//
+// Takes in an error channel which sends errors for repos that could not be
+// locked.  Those repos are left out of the returned slice.  The channel is
+// closed at the end of locking.
+func Lock(
+	ctx context.Context,
+	repos []Repo,
+	jobs int,
+	errCh chan error,
+) ([]Repo, error) {
+	if errCh == nil {
+		return nil, errs.ErrNilChan
+	}
+
+	defer close(errCh)
+
+	var (
+		mu     sync.Mutex
+		locked = make([]*Repo, len(repos))
+	)
+
+	forEach(ctx, repos, jobs, func(i int, r Repo) {
+		l, err := lockRepo(ctx, r)
+		if err != nil {
+			errCh <- fmt.Errorf("error locking %s: %w", r.Path, err)
+
+			return
+		}
+
+		mu.Lock()
+		locked[i] = &l
+		mu.Unlock()
+	})
+
+	if err := doneErr(ctx); err != nil {
+		return nil, err
+	}
+
+	lockfile := make([]Repo, 0, len(repos))
+
+	for _, l := range locked {
+		if l != nil {
+			lockfile = append(lockfile, *l)
+		}
+	}
+
+	if len(lockfile) < len(repos) {
+		return lockfile, errs.ErrOccurred
+	}
+
+	return lockfile, nil
+}
+
+func lockRepo(ctx context.Context, r Repo) (Repo, error) {
+	if _, err := os.Stat(r.Path); err != nil {
+		return r, errs.ErrNotCloned
+	}
+
+	commit, err := git.Head(ctx, r.Path)
+	if err != nil {
+		return r, err
+	}
+
+	// An error means HEAD is detached, so there is no branch to record.
+	branch, _ := git.Branch(ctx, r.Path)
+
+	r.Options.Branch = branch
+	r.Options.Commit = commit
+
+	return r, nil
+}
+
+// lockedAction checks out the locked commit of the repository, cloning or
+// fetching first as needed.  Local changes are never overwritten.
+func lockedAction(ctx context.Context, r Repo) (Action, string, error) {
+	reason := "locked at " + r.Options.Commit
+
+	if _, err := os.Stat(r.Path); err != nil {
+		if err := clone(ctx, r); err != nil {
+			return ActionClone, reasonNotCloned, err
+		}
+
+		return ActionCheckout, reason,
+			git.Checkout(ctx, r.Path, r.Options.Commit)
+	}
+
+	if head, _ := git.Head(ctx, r.Path); head == r.Options.Commit {
+		return ActionSkip, reasonUpToDate, nil
+	}
+
+	if worktreeChanges(ctx, r) != "" {
+		return ActionCheckout, reason, errs.ErrDirtyWorktree
+	}
+
+	fetchArgs := append(r.Options.fetchArgs(), r.RemoteName())
+	if err := git.Fetch(ctx, r.Path, fetchArgs...); err != nil {
+		return ActionFetch, "", err
+	}
+
+	return ActionCheckout, reason, git.Checkout(ctx, r.Path, r.Options.Commit)
+}
+
+// planLocked decides what lockedAction would do without changing anything.
+func planLocked(ctx context.Context, r Repo) (Action, string, error) {
+	if _, err := os.Stat(r.Path); err != nil {
+		return ActionClone, reasonNotCloned, nil
+	}
+
+	if head, _ := git.Head(ctx, r.Path); head == r.Options.Commit {
+		return ActionSkip, reasonUpToDate, nil
+	}
+
+	if reason := worktreeChanges(ctx, r); reason != "" {
+		return ActionCheckout, reason, errs.ErrDirtyWorktree
+	}
+
+	return ActionCheckout, "locked at " + r.Options.Commit, nil
+}
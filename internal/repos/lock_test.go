@@ -0,0 +1,117 @@
+package repos_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/git"
+	. "gitlab.com/kibafox/repos/internal/repos"
+)
+
+var _ = Describe("Lock", func() {
+	var (
+		repos []Repo
+		dir   string
+	)
+
+	BeforeEach(func() {
+		repos, dir = syncSetupRepos()
+		syncSimple(repos)
+	})
+
+	AfterEach(func() {
+		cleanRepos(dir)
+	})
+
+	It("records the branch and commit of each repository", func() {
+		heads := localHeadHashes(repos)
+
+		branch, err := git.Branch(context.Background(), repos[0].Path)
+		Expect(err).ToNot(HaveOccurred())
+
+		locked := lockSimple(repos)
+
+		Expect(locked).Should(HaveLen(len(repos)))
+
+		for i, l := range locked {
+			Expect(l.Path).Should(Equal(repos[i].Path))
+			Expect(l.Options.Branch).Should(Equal(branch))
+			Expect(l.Options.Commit).Should(Equal(heads[l.Path]))
+		}
+	})
+
+	It("fails for repositories that are not cloned", func() {
+		Expect(os.RemoveAll(repos[1].Path)).To(Succeed())
+
+		errCh := make(chan error, len(repos))
+
+		locked, err := Lock(context.Background(), repos, 1, errCh)
+		Expect(err).Should(Equal(errs.ErrOccurred))
+		Expect(locked).Should(HaveLen(1))
+
+		e := <-errCh
+		Expect(errors.Is(e, errs.ErrNotCloned)).Should(BeTrue())
+	})
+
+	It("restores repositories to the locked commits", func() {
+		locked := lockSimple(repos)
+		lockedHeads := localHeadHashes(repos)
+
+		By("Committing a CONTRIBUTING.md file to the remote repos")
+		for _, r := range repos {
+			makeCommit(r.URL, "CONTRIBUTING.md", "TODO\n",
+				"Add CONTRIBUTING.md")
+		}
+
+		syncSimple(repos)
+		Expect(localHeadHashes(repos)).ShouldNot(Equal(lockedHeads))
+
+		By("Removing the first local repo so it is cloned again")
+		Expect(os.RemoveAll(repos[0].Path)).To(Succeed())
+
+		for _, res := range syncSimpleOpts(locked, SyncOptions{Locked: true}) {
+			Expect(res.Action).Should(Equal(ActionCheckout))
+		}
+
+		Expect(localHeadHashes(repos)).Should(Equal(lockedHeads))
+
+		By("Syncing the lock again")
+		for _, res := range syncSimpleOpts(locked, SyncOptions{Locked: true}) {
+			Expect(res.Action).Should(Equal(ActionSkip))
+		}
+	})
+})
+
+// lockSimple will lock the repos, expecting it to complete successfully.
+func lockSimple(repos []Repo) []Repo {
+	var (
+		locked []Repo
+		err    error
+		errCh  = make(chan error, 1)
+		done   = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		locked, err = Lock(context.Background(), repos, 2, errCh)
+	}()
+
+	for e := range errCh {
+		log.Println(fmt.Errorf("lock: %w", e))
+		Fail(e.Error())
+	}
+
+	<-done
+
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return locked
+}
@@ -0,0 +1,97 @@
+package repos
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+)
+
+// Conflict is a repository found at a path that is already configured, but
+// with a different URL.
+type Conflict struct {
+	Existing Repo
+	Found    Repo
+}
+
+// Merge compares the found repos against the existing ones by path.  It
+// returns the found repos that are not configured yet, in the order they were
+// found, and the ones configured with a different URL.  Found repos without a
+// URL are left out since there is nothing to sync them from, as are submodules
+// since their parent repository manages them.
+//
+// Paths are compared as absolute paths, with the home directory expanded, so
+// that a repository found from another directory is still known.
+func Merge(
+	existing, found []Repo,
+) (added []Repo, conflicts []Conflict, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, errs.ErrHomeNotFound(err)
+	}
+
+	known := make(map[string]Repo, len(existing)+len(found))
+
+	for _, r := range existing {
+		path, err := filepath.Abs(ExpandHome(home, r.Path))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		known[path] = r
+	}
+
+	for _, r := range found {
+		if r.URL == "" || r.Kind == KindSubmodule {
+			continue
+		}
+
+		path, err := filepath.Abs(ExpandHome(home, r.Path))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		k, ok := known[path]
+
+		switch {
+		case !ok:
+			known[path] = r
+			added = append(added, r)
+		case k.URL != r.URL:
+			conflicts = append(conflicts, Conflict{Existing: k, Found: r})
+		}
+	}
+
+	return added, conflicts, nil
+}
+
+// AppendRepos writes the repos under the comment, to be appended to the
+// configuration content.  A line is started first when the content does not
+// end with one, and an empty `[]` header when the content ends in a tagged
+// section, so that the repos do not pick up its tags.
+func AppendRepos(
+	writer io.Writer,
+	content []byte,
+	comment string,
+	repos []Repo,
+) error {
+	var head string
+
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		head = "\n"
+	}
+
+	head += "\n"
+
+	if len(endTags(content)) > 0 {
+		head += "[]\n"
+	}
+
+	if _, err := fmt.Fprintf(writer, "%s# %s\n\n", head, comment); err != nil {
+		return fmt.Errorf("failed to write comment: %w", err)
+	}
+
+	return WriteRepos(repos, writer)
+}
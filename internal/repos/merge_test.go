@@ -0,0 +1,109 @@
+package repos_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "gitlab.com/kibafox/repos/internal/repos"
+)
+
+var _ = Describe("Merge", func() {
+	existing := []Repo{
+		{Path: "/src/a", URL: "https://example.com/a.git"},
+		{Path: "/src/b", URL: "https://example.com/b.git"},
+	}
+
+	It("adds only repos at new paths", func() {
+		found := []Repo{
+			{Path: "/src/a/", URL: "https://example.com/a.git"},
+			{Path: "/src/c", URL: "https://example.com/c.git"},
+		}
+
+		added, conflicts, err := Merge(existing, found)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).Should(Equal(found[1:]))
+		Expect(conflicts).Should(BeEmpty())
+	})
+
+	It("reports repos configured with a different URL", func() {
+		found := []Repo{{Path: "/src/b", URL: "https://example.com/fork.git"}}
+
+		added, conflicts, err := Merge(existing, found)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).Should(BeEmpty())
+		Expect(conflicts).Should(Equal([]Conflict{
+			{Existing: existing[1], Found: found[0]},
+		}))
+	})
+
+	It("leaves out repos without a URL and duplicates", func() {
+		found := []Repo{
+			{Path: "/src/d"},
+			{Path: "/src/e", URL: "https://example.com/e.git"},
+			{Path: "/src/e", URL: "https://example.com/e.git"},
+		}
+
+		added, _, err := Merge(existing, found)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).Should(Equal(found[1:2]))
+	})
+
+	It("knows repos found by a relative path", func() {
+		wd, err := os.Getwd()
+		Expect(err).ToNot(HaveOccurred())
+
+		src := filepath.Join(wd, "src")
+
+		existing := []Repo{
+			{Path: "src/a", URL: "https://example.com/a.git"},
+			{Path: src + "/b", URL: "https://example.com/b.git"},
+		}
+		found := []Repo{
+			{Path: src + "/a", URL: "https://example.com/a.git"},
+			{Path: "./src/b", URL: "https://example.com/b.git"},
+		}
+
+		added, conflicts, err := Merge(existing, found)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).Should(BeEmpty())
+		Expect(conflicts).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("AppendRepos", func() {
+	added := []Repo{
+		{Path: "/src/c", URL: "https://example.com/c.git"},
+		{Path: "/src/d", URL: "https://example.com/d.git"},
+	}
+
+	It("stops the tagged section the configuration ends in", func() {
+		content := []byte("/src/a https://example.com/a.git\n" +
+			"[work]\n/src/b https://example.com/b.git")
+
+		var buf bytes.Buffer
+		Expect(AppendRepos(&buf, content, "Imported", added)).To(Succeed())
+
+		merged := parseSimple(strings.NewReader(string(content) + buf.String()))
+		Expect(merged).Should(HaveLen(4))
+		Expect(merged[1].Tags).Should(Equal([]string{"work"}))
+
+		for _, r := range merged[2:] {
+			Expect(r.Tags).Should(BeEmpty())
+		}
+	})
+
+	It("adds no header after an untagged section", func() {
+		content := []byte("[work]\n/src/a https://example.com/a.git\n[]\n")
+
+		var buf bytes.Buffer
+		Expect(AppendRepos(&buf, content, "Imported", added)).To(Succeed())
+
+		Expect(buf.String()).ShouldNot(ContainSubstring("["))
+		Expect(buf.String()).Should(HavePrefix("\n# Imported\n\n"))
+	})
+})
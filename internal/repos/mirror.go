@@ -0,0 +1,287 @@
+package repos
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/git"
+)
+
+// MirrorOptions changes how Mirror processes the repositories.
+type MirrorOptions struct {
+	// Dest is the directory the mirrors are kept in.
+	Dest string
+
+	// Jobs is the maximum number of mirrors updated at the same time.  A value
+	// less than 1 updates one mirror at a time.
+	Jobs int
+
+	// Retry is how URLs that failed to mirror in a way that may not happen
+	// again, such as a dropped network connection, are retried.
+	Retry Retry
+}
+
+// RefChange is a ref of a mirror that was rewritten or deleted upstream.
+type RefChange struct {
+	Ref string
+	// Old is the commit the ref pointed to before the update.
+	Old string
+	// New is the commit the ref points to now.  It is empty when the ref was
+	// deleted.
+	New string
+}
+
+// Deleted checks if the ref was deleted, rather than force-pushed.
+func (c RefChange) Deleted() bool {
+	return c.New == ""
+}
+
+// MirrorResult is the outcome of mirroring a single URL.
+type MirrorResult struct {
+	// Repo has the URL mirrored and the path of the mirror.
+	Repo Repo
+	// Action is ActionClone when the mirror was created, or ActionFetch when
+	// it was updated.
+	Action Action
+	// Changes are the refs that were force-pushed or deleted upstream since
+	// the last update, sorted by ref.
+	Changes []RefChange
+	// Duration is how long mirroring took.
+	Duration time.Duration
+	// Attempts is how many times mirroring was tried, more than one when
+	// transient failures were retried.
+	Attempts int
+	// Err is set when the URL failed to mirror.  It is an errs.RepoError.
+	Err error
+}
+
+// Mirror keeps a bare mirror of every URL of the repos in opts.Dest.  Each URL,
+// including the ones of other remotes, is mirrored once at MirrorPath:
+//
+//   - `git clone --mirror` when the mirror does not exist.
+//   - `git remote update --prune` otherwise, reporting the refs that were
+//     force-pushed or deleted.
+//
+// A URL failing in a way that may not happen again, such as a dropped network
+// connection, is tried again as set by opts.Retry.
+//
+// Takes in a result channel which is sent the result of every mirror as soon as
+// it is done.  The channel is closed at the end of mirroring.
+// errs.ErrOccurred is returned when any URL failed to mirror.
+func Mirror(
+	ctx context.Context,
+	repos []Repo,
+	opts MirrorOptions,
+	resCh chan MirrorResult,
+) error {
+	if resCh == nil {
+		return errs.ErrNilChan
+	}
+
+	defer close(resCh)
+
+	if opts.Dest == "" {
+		return errs.ErrNoDest
+	}
+
+	var (
+		mu          sync.Mutex
+		errOccurred bool
+	)
+
+	forEach(ctx, mirrors(opts.Dest, repos), opts.Jobs, func(_ int, m Repo) {
+		res := mirrorRepo(ctx, m, opts.Retry)
+		if res.Err != nil {
+			mu.Lock()
+			errOccurred = true
+			mu.Unlock()
+		}
+
+		resCh <- res
+	})
+
+	if err := doneErr(ctx); err != nil {
+		return err
+	}
+
+	if errOccurred {
+		return errs.ErrOccurred
+	}
+
+	return nil
+}
+
+// mirrors lists every URL of the repos once, with the path of its mirror.
+func mirrors(dest string, repos []Repo) []Repo {
+	var (
+		seen = make(map[string]bool)
+		list []Repo
+	)
+
+	add := func(u string) {
+		p := MirrorPath(dest, u)
+		if u == "" || seen[p] {
+			return
+		}
+
+		seen[p] = true
+
+		list = append(list, Repo{Path: p, URL: u})
+	}
+
+	for _, r := range repos {
+		add(r.URL)
+
+		for _, remote := range r.Remotes {
+			add(remote.URL)
+		}
+	}
+
+	return list
+}
+
+// MirrorPath is where the mirror of the URL is kept in the dest directory.  It
+// is made of the host and path of the URL, ending with ".git", such as
+// "DEST/gitlab.com/kibafox/repos.git" for "git@gitlab.com:kibafox/repos".
+func MirrorPath(dest, rawURL string) string {
+	var host, path string
+
+	colon := strings.Index(rawURL, ":")
+	slash := strings.Index(rawURL, "/")
+
+	switch {
+	case strings.Contains(rawURL, "://"):
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			path = rawURL
+
+			break
+		}
+
+		host, path = u.Hostname(), u.Path
+	case colon > 0 && (slash < 0 || colon < slash):
+		// The scp-like syntax of ssh: [USER@]HOST:PATH
+		host, path = rawURL[:colon], rawURL[colon+1:]
+		host = host[strings.LastIndex(host, "@")+1:]
+	default:
+		path = rawURL
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git") + ".git"
+
+	// Cleaning from the root keeps ".." from leaving the dest directory.
+	return filepath.Join(dest, host, filepath.Clean("/"+path))
+}
+
+func mirrorRepo(ctx context.Context, m Repo, retry Retry) MirrorResult {
+	start := time.Now()
+	res := MirrorResult{Repo: m}
+
+	res.Attempts, res.Err = retry.do(ctx, func() (err error) {
+		if _, err := os.Stat(m.Path); err != nil {
+			res.Action = ActionClone
+
+			return cloneAtomic(m.Path, func(tmp string) error {
+				return git.Clone(ctx, m.URL, tmp, "--mirror")
+			})
+		}
+
+		res.Action = ActionFetch
+		res.Changes, err = updateMirror(ctx, m.Path)
+
+		return err
+	})
+
+	if res.Err != nil {
+		res.Err = &errs.RepoError{
+			Path: m.Path,
+			URL:  m.URL,
+			Op:   string(res.Action),
+			Err:  res.Err,
+		}
+	}
+
+	res.Duration = time.Since(start)
+
+	return res
+}
+
+// updateMirror updates the mirror and compares its refs before and after to
+// find the ones that were force-pushed or deleted.
+func updateMirror(ctx context.Context, path string) ([]RefChange, error) {
+	before, err := git.Refs(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := git.RemoteUpdate(ctx, path); err != nil {
+		return nil, err
+	}
+
+	after, err := git.Refs(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RefChange
+
+	for ref, old := range before {
+		updated, ok := after[ref]
+
+		switch {
+		case !ok:
+			changes = append(changes, RefChange{Ref: ref, Old: old})
+		case updated != old && !git.IsAncestor(ctx, path, old, updated):
+			changes = append(changes,
+				RefChange{Ref: ref, Old: old, New: updated})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Ref < changes[j].Ref
+	})
+
+	return changes, nil
+}
+
+// MirrorSummary counts the results of mirroring.
+type MirrorSummary struct {
+	// Cloned is the number of mirrors that were created.
+	Cloned int
+	// Updated is the number of existing mirrors that were updated.
+	Updated int
+	// Failed is the number of URLs that failed to mirror.
+	Failed int
+	// Failures counts the failed URLs by the errs.KindName of their error,
+	// such as "auth" or "network".
+	Failures map[string]int
+	// Changes is the number of refs force-pushed or deleted upstream.
+	Changes int
+}
+
+// Add counts the result in the summary.
+func (s *MirrorSummary) Add(res MirrorResult) {
+	switch {
+	case res.Err != nil:
+		s.Failed++
+		s.Failures = addFailure(s.Failures, res.Err)
+	case res.Action == ActionClone:
+		s.Cloned++
+	default:
+		s.Updated++
+	}
+
+	s.Changes += len(res.Changes)
+}
+
+// Total is the number of results counted in the summary.
+func (s MirrorSummary) Total() int {
+	return s.Cloned + s.Updated + s.Failed
+}
@@ -0,0 +1,104 @@
+package repos_test
+
+import (
+	"context"
+	"path"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/kibafox/repos/internal/git"
+	. "gitlab.com/kibafox/repos/internal/repos"
+)
+
+var _ = Describe("Mirror", func() {
+	It("derives the mirror path from the host and path of the URL", func() {
+		for u, p := range map[string]string{
+			"git@gitlab.com:kiba/repos":         "/m/gitlab.com/kiba/repos.git",
+			"https://github.com/kira/klok.git":  "/m/github.com/kira/klok.git",
+			"ssh://git@host:2222/srv/repo.git/": "/m/host/srv/repo.git",
+			"file:///srv/../../../etc/secrets":  "/m/etc/secrets.git",
+			"/srv/git/local":                    "/m/srv/git/local.git",
+		} {
+			Expect(MirrorPath("/m", u)).Should(Equal(p), u)
+		}
+	})
+
+	It("mirrors every URL and reports rewritten refs", func() {
+		repos, dir := syncSetupRepos()
+		defer cleanRepos(dir)
+
+		ctx := context.Background()
+		dest := path.Join(dir, "mirrors")
+		remote := repos[0].URL
+
+		repos[0].Remotes = []Remote{{Name: "upstream", URL: repos[1].URL}}
+		repos = append(repos, Repo{Path: "again", URL: repos[1].URL})
+
+		By("Cloning a mirror of each URL once")
+		results := mirrorSimple(repos, dest)
+		Expect(results).Should(HaveLen(2))
+
+		for _, res := range results {
+			Expect(res.Action).Should(Equal(ActionClone))
+			Expect(path.Join(res.Repo.Path, "HEAD")).Should(BeARegularFile())
+		}
+
+		By("Force-pushing one branch and deleting another")
+		branch, err := git.Branch(ctx, remote)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(git.Run(ctx, "-C", remote, "branch", "gone")).To(Succeed())
+		mirrorSimple(repos[:1], dest)
+
+		old := repoHeadHash(remote)
+
+		Expect(git.Run(ctx, "-C", remote,
+			"commit", "--amend", "--quiet", "-m", "Rewritten")).To(Succeed())
+		Expect(git.Run(ctx, "-C", remote, "branch", "-D", "gone")).
+			To(Succeed())
+
+		results = mirrorSimple(repos[:1], dest)
+		Expect(results[0].Action).Should(Equal(ActionFetch))
+		Expect(results[0].Changes).Should(ConsistOf(
+			RefChange{
+				Ref: "refs/heads/" + branch,
+				Old: old,
+				New: repoHeadHash(remote),
+			},
+			RefChange{Ref: "refs/heads/gone", Old: old},
+		))
+	})
+})
+
+// mirrorSimple will mirror the repos, expecting every URL to mirror.
+func mirrorSimple(repos []Repo, dest string) []MirrorResult {
+	var (
+		err         error
+		results     []MirrorResult
+		resCh       = make(chan MirrorResult, 1)
+		done        = make(chan struct{})
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	)
+
+	defer cancel()
+
+	go func() {
+		defer close(done)
+
+		for res := range resCh {
+			Expect(res.Err).ToNot(HaveOccurred())
+
+			results = append(results, res)
+		}
+	}()
+
+	err = Mirror(ctx, repos, MirrorOptions{Dest: dest}, resCh)
+
+	<-done
+
+	Expect(err).ToNot(HaveOccurred())
+
+	return results
+}
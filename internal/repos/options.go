@@ -0,0 +1,253 @@
+package repos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+)
+
+// DefaultRemote is the name of the remote used when none is configured.
+const DefaultRemote = "origin"
+
+// Options are settings for a single repository.  They are given as KEY=VALUE
+// fields after the PATH and URL of a configuration line:
+//
+//	branch=NAME   branch to clone and keep checked out
+//	depth=N       only clone and fetch the last N commits
+//	filter=SPEC   partial clone filter, such as blob:none or tree:0
+//	sparse=DIRS   comma separated directories for a sparse checkout
+//	remote=NAME   name of the remote for the URL (default: origin)
+//	tags=BOOL     set to false to not fetch tags
+//	commit=HASH   commit to check out when syncing with a lock
+//
+// The kind of repository, kind=bare or kind=worktree, and other remotes, as
+// remote.NAME=URL, are also given as fields but are kept on the Repo instead.
+type Options struct {
+	Branch string   `json:"branch,omitempty"`
+	Depth  int      `json:"depth,omitempty"`
+	Filter string   `json:"filter,omitempty"`
+	Sparse []string `json:"sparse,omitempty"`
+	Remote string   `json:"remote,omitempty"`
+	NoTags bool     `json:"no_tags,omitempty"`
+	Commit string   `json:"commit,omitempty"`
+}
+
+// setFields parses the KEY=VALUE fields after the PATH and URL into the repo.
+// Besides the Options, they set the kind of repo and its other remotes.
+func (r *Repo) setFields(fields []string) error {
+	for _, field := range fields {
+		n := strings.Index(field, "=")
+		if n < 1 {
+			return errs.ErrParseLine
+		}
+
+		key, val := field[:n], field[n+1:]
+
+		switch {
+		case key == "kind":
+			k, err := parseKind(val)
+			if err != nil {
+				return err
+			}
+
+			r.Kind = k
+		case strings.HasPrefix(key, "remote."):
+			name := strings.TrimPrefix(key, "remote.")
+			if name == "" || val == "" {
+				return fmt.Errorf("%w: %s=%s", errs.ErrOptionValue, key, val)
+			}
+
+			r.Remotes = append(r.Remotes, Remote{Name: name, URL: val})
+		default:
+			if err := r.Options.set(key, val); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseKind parses the kind of a configured repository.  Submodules are not
+// accepted since they are managed by their parent repository.
+func parseKind(val string) (Kind, error) {
+	switch k := Kind(val); k {
+	case KindBare, KindWorktree:
+		return k, nil
+	default:
+		return "", fmt.Errorf("%w: kind=%s", errs.ErrOptionValue, val)
+	}
+}
+
+func (o *Options) set(key, val string) error {
+	switch key {
+	case "branch":
+		o.Branch = val
+	case "depth":
+		depth, err := strconv.Atoi(val)
+		if err != nil || depth < 0 {
+			return fmt.Errorf("%w: %s=%s", errs.ErrOptionValue, key, val)
+		}
+
+		o.Depth = depth
+	case "filter":
+		if val == "" {
+			return fmt.Errorf("%w: %s=%s", errs.ErrOptionValue, key, val)
+		}
+
+		o.Filter = val
+	case "sparse":
+		o.Sparse = nil
+
+		for _, dir := range strings.Split(val, ",") {
+			if dir == "" {
+				return fmt.Errorf("%w: %s=%s", errs.ErrOptionValue, key, val)
+			}
+
+			o.Sparse = append(o.Sparse, dir)
+		}
+	case "remote":
+		o.Remote = val
+	case "tags":
+		tags, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("%w: %s=%s", errs.ErrOptionValue, key, val)
+		}
+
+		o.NoTags = !tags
+	case "commit":
+		o.Commit = val
+	default:
+		return fmt.Errorf("%w: %s", errs.ErrUnknownOption, key)
+	}
+
+	return nil
+}
+
+// Fields returns the options as KEY=VALUE fields for a configuration line.
+// Options that are not set are left out.
+func (o Options) Fields() []string {
+	var fields []string
+
+	if o.Branch != "" {
+		fields = append(fields, "branch="+o.Branch)
+	}
+
+	if o.Depth > 0 {
+		fields = append(fields, "depth="+strconv.Itoa(o.Depth))
+	}
+
+	if o.Filter != "" {
+		fields = append(fields, "filter="+o.Filter)
+	}
+
+	if len(o.Sparse) > 0 {
+		fields = append(fields, "sparse="+strings.Join(o.Sparse, ","))
+	}
+
+	if o.Remote != "" {
+		fields = append(fields, "remote="+o.Remote)
+	}
+
+	if o.NoTags {
+		fields = append(fields, "tags=false")
+	}
+
+	if o.Commit != "" {
+		fields = append(fields, "commit="+o.Commit)
+	}
+
+	return fields
+}
+
+// Fields returns the options, kind and other remotes of the repo as KEY=VALUE
+// fields for a configuration line.
+func (r Repo) Fields() []string {
+	fields := r.Options.Fields()
+
+	if r.Kind != "" {
+		fields = append(fields, "kind="+string(r.Kind))
+	}
+
+	for _, remote := range r.Remotes {
+		fields = append(fields, "remote."+remote.Name+"="+remote.URL)
+	}
+
+	return fields
+}
+
+// RemoteName is the name of the remote for the URL of the repo.
+func (r Repo) RemoteName() string {
+	if r.Options.Remote != "" {
+		return r.Options.Remote
+	}
+
+	return DefaultRemote
+}
+
+// cloneArgs are the extra `git clone` arguments for the options.
+func (o Options) cloneArgs() []string {
+	var args []string
+
+	if o.Branch != "" {
+		args = append(args, "--branch", o.Branch)
+	}
+
+	if o.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(o.Depth))
+	}
+
+	if o.Filter != "" {
+		args = append(args, "--filter="+o.Filter)
+	}
+
+	if len(o.Sparse) > 0 {
+		args = append(args, "--sparse")
+	}
+
+	if o.Remote != "" {
+		args = append(args, "--origin", o.Remote)
+	}
+
+	if o.NoTags {
+		args = append(args, "--no-tags")
+	}
+
+	return args
+}
+
+// withDefaults returns the options with the depth, filter and sparse options
+// of the defaults used where they are not set.
+func (o Options) withDefaults(d Options) Options {
+	if o.Depth == 0 {
+		o.Depth = d.Depth
+	}
+
+	if o.Filter == "" {
+		o.Filter = d.Filter
+	}
+
+	if len(o.Sparse) == 0 {
+		o.Sparse = d.Sparse
+	}
+
+	return o
+}
+
+// fetchArgs are the extra `git fetch` arguments for the options.  A partial
+// clone remembers its filter, so it is not given again.
+func (o Options) fetchArgs() []string {
+	var args []string
+
+	if o.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(o.Depth))
+	}
+
+	if o.NoTags {
+		args = append(args, "--no-tags")
+	}
+
+	return args
+}
@@ -2,6 +2,7 @@ package repos
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -12,6 +13,10 @@ import (
 
 // Parse will read the configuration file format and returns the parsed slice of
 // git repositories.
+//
+// A section header such as `[work oss]` tags every repository that follows it
+// with "work" and "oss", until the next header.  An empty header, `[]`, stops
+// tagging.
 func Parse(reader io.Reader, errCh chan error) ([]Repo, error) {
 	repos := make([]Repo, 0, 9)
 
@@ -29,6 +34,7 @@ func Parse(reader io.Reader, errCh chan error) ([]Repo, error) {
 	var (
 		linenum     uint
 		errOccurred bool
+		tags        []string
 	)
 
 	scanner := bufio.NewScanner(reader)
@@ -37,6 +43,12 @@ func Parse(reader io.Reader, errCh chan error) ([]Repo, error) {
 
 		str := scanner.Text()
 
+		if t, ok := parseHeader(str); ok {
+			tags = t
+
+			continue
+		}
+
 		r, err := parseLine(home, str)
 		if err != nil {
 			errOccurred = true
@@ -49,6 +61,7 @@ func Parse(reader io.Reader, errCh chan error) ([]Repo, error) {
 			continue
 		}
 
+		r.Tags = tags
 		repos = append(repos, *r)
 	}
 
@@ -63,6 +76,38 @@ func Parse(reader io.Reader, errCh chan error) ([]Repo, error) {
 	return repos, nil
 }
 
+// endTags returns the tags of the section the configuration content ends in.
+// It is nil when there is no section or the last header stops tagging.
+func endTags(content []byte) []string {
+	var tags []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if t, ok := parseHeader(scanner.Text()); ok {
+			tags = t
+		}
+	}
+
+	return tags
+}
+
+// parseHeader returns the tags of a section header line.  It returns false when
+// the line is not a header.
+func parseHeader(line string) ([]string, bool) {
+	line = strings.TrimSpace(line)
+
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return nil, false
+	}
+
+	tags := strings.Fields(line[1 : len(line)-1])
+	if len(tags) == 0 {
+		return nil, true
+	}
+
+	return tags, true
+}
+
 func parseLine(home, line string) (r *Repo, err error) {
 	if strings.HasPrefix(line, "#") || len(line) == 0 {
 		// ignore comments and empty lines
@@ -72,7 +117,7 @@ func parseLine(home, line string) (r *Repo, err error) {
 	fields := strings.Fields(line)
 	if len(fields) == 0 {
 		return nil, nil
-	} else if len(fields) != 2 {
+	} else if len(fields) < 2 {
 		return nil, errs.ErrParseLine
 	}
 
@@ -81,5 +126,9 @@ func parseLine(home, line string) (r *Repo, err error) {
 		URL:  fields[1],
 	}
 
+	if err := r.setFields(fields[2:]); err != nil {
+		return nil, err
+	}
+
 	return r, nil
 }
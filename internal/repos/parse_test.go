@@ -118,6 +118,127 @@ var _ = Describe("Parse", func() {
 		Expect(repos).Should(HaveLen(0))
 	})
 
+	It("Parses KEY=VALUE options after PATH and URL", func() {
+		config := "/home/user/proj/test git@gitlab.com/user/test " +
+			"branch=release depth=1 remote=upstream tags=false"
+
+		repos := parseSimple(strings.NewReader(config))
+
+		Expect(repos).Should(ConsistOf(
+			Repo{
+				Path: "/home/user/proj/test",
+				URL:  "git@gitlab.com/user/test",
+				Options: Options{
+					Branch: "release",
+					Depth:  1,
+					Remote: "upstream",
+					NoTags: true,
+				},
+			},
+		))
+	})
+
+	It("Parses partial clone and sparse checkout options", func() {
+		config := "/home/user/proj/mono git@gitlab.com/user/mono " +
+			"filter=blob:limit=1m sparse=docs,tools/ci"
+
+		repos := parseSimple(strings.NewReader(config))
+
+		Expect(repos).Should(ConsistOf(
+			Repo{
+				Path: "/home/user/proj/mono",
+				URL:  "git@gitlab.com/user/mono",
+				Options: Options{
+					Filter: "blob:limit=1m",
+					Sparse: []string{"docs", "tools/ci"},
+				},
+			},
+		))
+	})
+
+	It("Parses other remotes of a repo", func() {
+		config := "/home/user/proj/test git@gitlab.com/user/test " +
+			"remote.upstream=git@gitlab.com/kiba/test remote.mirror=/srv/test"
+
+		repos := parseSimple(strings.NewReader(config))
+
+		Expect(repos).Should(ConsistOf(
+			Repo{
+				Path: "/home/user/proj/test",
+				URL:  "git@gitlab.com/user/test",
+				Remotes: []Remote{
+					{Name: "upstream", URL: "git@gitlab.com/kiba/test"},
+					{Name: "mirror", URL: "/srv/test"},
+				},
+			},
+		))
+	})
+
+	It("Parses the kind of repo", func() {
+		config := "/home/user/proj/test.git git@gitlab.com/user/test kind=bare"
+
+		repos := parseSimple(strings.NewReader(config))
+
+		Expect(repos).Should(ConsistOf(
+			Repo{
+				Path: "/home/user/proj/test.git",
+				URL:  "git@gitlab.com/user/test",
+				Kind: KindBare,
+			},
+		))
+	})
+
+	It("Skips when the kind is a submodule", func() {
+		config := "/home/user/proj/sub git@gitlab.com/user/sub kind=submodule"
+
+		repos := parseErr(strings.NewReader(config), errs.ErrOptionValue)
+
+		Expect(repos).Should(HaveLen(0))
+	})
+
+	It("Tags repos with the section header they follow", func() {
+		config := `/home/user/proj/none git@gitlab.com/user/none
+[work oss]
+/home/user/proj/work git@gitlab.com/user/work
+[]
+/home/user/proj/done git@gitlab.com/user/done
+`
+
+		repos := parseSimple(strings.NewReader(config))
+
+		Expect(repos).Should(Equal([]Repo{
+			{
+				Path: "/home/user/proj/none",
+				URL:  "git@gitlab.com/user/none",
+			},
+			{
+				Path: "/home/user/proj/work",
+				URL:  "git@gitlab.com/user/work",
+				Tags: []string{"work", "oss"},
+			},
+			{
+				Path: "/home/user/proj/done",
+				URL:  "git@gitlab.com/user/done",
+			},
+		}))
+	})
+
+	It("Skips when an option is unknown", func() {
+		config := "/home/user/proj/test git@gitlab.com/user/test bogus=1"
+
+		repos := parseErr(strings.NewReader(config), errs.ErrUnknownOption)
+
+		Expect(repos).Should(HaveLen(0))
+	})
+
+	It("Skips when an option has an invalid value", func() {
+		config := "/home/user/proj/test git@gitlab.com/user/test depth=all"
+
+		repos := parseErr(strings.NewReader(config), errs.ErrOptionValue)
+
+		Expect(repos).Should(HaveLen(0))
+	})
+
 	It("Skips when more fields than PATH and URL are given", func() {
 		config := "/home/user/proj/test git@gitlab.com/user/test git@github.com"
 
@@ -165,7 +286,7 @@ func parseErr(reader io.Reader, err error) []Repo {
 
 	for e := range errChan {
 		log.Println(fmt.Errorf("parse: %w", e))
-		Expect(errors.Unwrap(e)).Should(Equal(err))
+		Expect(errors.Is(e, err)).Should(BeTrue())
 	}
 
 	Expect(parseErr).Should(HaveOccurred())
@@ -0,0 +1,122 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+)
+
+type stopKey struct{}
+
+// WithStop returns a context that makes the functions working on many
+// repositories, such as Sync, stop starting new ones once stop is closed.  The
+// repositories being worked on are finished, unless the context is done too,
+// and errs.ErrInterrupted is returned.  This lets a program stop on its first
+// interrupt without killing git in the middle of a clone.
+func WithStop(ctx context.Context, stop <-chan struct{}) context.Context {
+	return context.WithValue(ctx, stopKey{}, stop)
+}
+
+// stopped returns the stop channel of the context given to WithStop.  It is
+// nil, and never closed, for other contexts.
+func stopped(ctx context.Context) <-chan struct{} {
+	stop, _ := ctx.Value(stopKey{}).(<-chan struct{})
+
+	return stop
+}
+
+// forEach calls fn for every repo, along with its index, using at most jobs
+// concurrent workers.  A jobs value less than 1 means one repo is processed at
+// a time.  No more repos are handed out once the context is done or stopped.
+// It returns after every worker has finished.
+func forEach(
+	ctx context.Context,
+	repos []Repo,
+	jobs int,
+	fn func(int, Repo),
+) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	if jobs > len(repos) {
+		jobs = len(repos)
+	}
+
+	var wg sync.WaitGroup
+
+	queue := make(chan int)
+
+	wg.Add(jobs)
+
+	for n := 0; n < jobs; n++ {
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				fn(i, repos[i])
+			}
+		}()
+	}
+
+dispatch:
+	for i := range repos {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case <-stopped(ctx):
+			break dispatch
+		case queue <- i:
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+}
+
+// cancelOnStop returns a context that is canceled once the context is stopped,
+// for work that has nothing to finish.
+func cancelOnStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-stopped(ctx):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// doneErr returns errs.ErrInterrupted for a stopped context, or the error of a
+// done context.  It returns nil otherwise.
+func doneErr(ctx context.Context) error {
+	select {
+	case <-stopped(ctx):
+		return errs.ErrInterrupted
+	default:
+	}
+
+	if ctx.Err() != nil {
+		return contextErr(ctx)
+	}
+
+	return nil
+}
+
+// contextErr converts the error of a done context to one of the errs package
+// errors.
+func contextErr(ctx context.Context) error {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return errs.ErrContextCanceled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return errs.ErrContextTimeout
+	default:
+		return errs.ErrContext(ctx)
+	}
+}
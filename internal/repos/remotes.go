@@ -0,0 +1,104 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/kibafox/repos/internal/git"
+)
+
+// RemoteDrift is a remote of a local repository with another URL than the one
+// in the configuration, such as after a repository moved to another host.
+type RemoteDrift struct {
+	// Name is the name of the remote, such as "origin".
+	Name string `json:"name"`
+	// URL is the URL of the remote in the local repository.
+	URL string `json:"url"`
+	// Want is the URL of the remote in the configuration.
+	Want string `json:"want"`
+}
+
+func (d RemoteDrift) String() string {
+	return fmt.Sprintf("remote %s is %s instead of %s", d.Name, d.URL, d.Want)
+}
+
+// remoteDrifts returns the remotes of the local repository of the repo with
+// other URLs than the configured ones.  Missing remotes are left out, since
+// syncing adds them.
+func remoteDrifts(ctx context.Context, r Repo) []RemoteDrift {
+	var drifts []RemoteDrift
+
+	remotes := append([]Remote{{Name: r.RemoteName(), URL: r.URL}},
+		r.Remotes...)
+
+	for _, remote := range remotes {
+		url, err := git.RemoteURL(ctx, r.Path, remote.Name)
+		if err != nil || sameURL(url, remote.URL) {
+			continue
+		}
+
+		drifts = append(drifts, RemoteDrift{
+			Name: remote.Name,
+			URL:  url,
+			Want: remote.URL,
+		})
+	}
+
+	return drifts
+}
+
+// fixRemotes sets the remotes that drifted to their configured URLs.  Local
+// paths are made absolute, as a clone does, since git would otherwise find
+// them from the repository rather than the current directory.
+func fixRemotes(ctx context.Context, r Repo, drifts []RemoteDrift) error {
+	for _, d := range drifts {
+		url := d.Want
+
+		if localURL(url) {
+			abs, err := filepath.Abs(url)
+			if err != nil {
+				return err
+			}
+
+			url = abs
+		}
+
+		if err := git.SetRemoteURL(ctx, r.Path, d.Name, url); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sameURL checks if two remote URLs point to the same repository.  Local
+// paths are compared as absolute paths, since git keeps them as given.
+func sameURL(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	if !localURL(a) || !localURL(b) {
+		return false
+	}
+
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+
+	return errA == nil && errB == nil && absA == absB
+}
+
+// localURL checks if the URL is a local path rather than a URL with a scheme
+// or the scp-like syntax of ssh, [USER@]HOST:PATH.
+func localURL(url string) bool {
+	if strings.Contains(url, "://") {
+		return false
+	}
+
+	colon := strings.Index(url, ":")
+	slash := strings.Index(url, "/")
+
+	return colon < 0 || (slash >= 0 && slash < colon)
+}
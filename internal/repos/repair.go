@@ -0,0 +1,440 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+	"gitlab.com/kibafox/repos/internal/git"
+)
+
+// ActionRepair means a broken repository was cloned again, keeping its local
+// branches.
+const ActionRepair Action = "repair"
+
+// Reasons given for the state of a local repository.
+const (
+	reasonEmpty      = "empty directory"
+	reasonIncomplete = "clone did not finish"
+	reasonNotRepo    = "not a git repository"
+	reasonBroken     = "repository is broken"
+)
+
+// checkoutState is what is found at the path of a repository.
+type checkoutState int
+
+const (
+	// stateOK is a repository git can read.
+	stateOK checkoutState = iota
+	// stateMissing is nothing at all.
+	stateMissing
+	// stateEmpty is an empty directory.
+	stateEmpty
+	// stateIncomplete is a git directory without any refs and nothing else,
+	// as left by a clone that did not finish.
+	stateIncomplete
+	// stateNotRepo is a directory with files but no repository.
+	stateNotRepo
+	// stateBroken is a repository git fails to read.
+	stateBroken
+)
+
+// inspect finds out the state of the local repository, so that a clone is
+// only done where nothing would be lost.  A repository is only broken when it
+// is corrupt.  Other failures of git, such as refusing a repository owned by
+// another user, are returned as they are, and the state is then meaningless.
+func inspect(ctx context.Context, r Repo) (checkoutState, error) {
+	entries, err := os.ReadDir(r.Path)
+
+	switch {
+	case os.IsNotExist(err):
+		return stateMissing, nil
+	case err != nil:
+		return stateNotRepo, err
+	case len(entries) == 0:
+		return stateEmpty, nil
+	}
+
+	gitDir := hasEntry(entries, ".git")
+
+	switch {
+	case r.Kind == KindBare && !isBare(entries):
+		return stateNotRepo, nil
+	case r.Kind != KindBare && gitDir == nil:
+		return stateNotRepo, nil
+	}
+
+	own, err := ownRepo(ctx, r)
+	if err != nil {
+		return stateOK, err
+	}
+
+	if !own {
+		return stateBroken, headErr(r, gitDir)
+	}
+
+	refs, err := git.Out(ctx, "-C", r.Path, "for-each-ref", "--count=1")
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return stateOK, contextErr(ctx)
+	case corrupt(err):
+		return stateBroken, nil
+	case err != nil:
+		return stateOK, err
+	}
+
+	// Only a regular clone is known to leave nothing but its git directory.
+	if refs == "" && len(entries) == 1 && gitDir != nil && gitDir.IsDir() {
+		return stateIncomplete, nil
+	}
+
+	return stateOK, nil
+}
+
+// ownRepo checks if git reads the repository at the path of the repo.  Git
+// looks for a repository in the parent directories when it does not recognize
+// this one, such as when HEAD is corrupt, so the one it found is checked.
+// Failures other than not finding a repository are returned as they are, such
+// as git refusing a repository owned by another user.
+func ownRepo(ctx context.Context, r Repo) (bool, error) {
+	arg := "--show-toplevel"
+	if r.Kind == KindBare {
+		arg = "--absolute-git-dir"
+	}
+
+	dir, err := git.Out(ctx, "-C", r.Path, "rev-parse", arg)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return false, contextErr(ctx)
+	case err != nil && notRepoMessage.MatchString(gitStderr(err)):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	want, err := filepath.EvalSymlinks(r.Path)
+	if err != nil {
+		return false, err
+	}
+
+	got, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false, err
+	}
+
+	want, _ = filepath.Abs(want)
+	got, _ = filepath.Abs(got)
+
+	return got == want, nil
+}
+
+// headErr returns why the HEAD of a repository git does not recognize cannot
+// be read, when it is not because it is missing.  A HEAD that cannot be read
+// for another reason, such as its permissions, does not make the repository
+// broken.
+func headErr(r Repo, gitDir fs.DirEntry) error {
+	head := filepath.Join(r.Path, "HEAD")
+
+	switch {
+	case r.Kind == KindBare:
+	case gitDir != nil && gitDir.IsDir():
+		head = filepath.Join(r.Path, ".git", "HEAD")
+	default:
+		// The git directory of a ".git" file is not read here.
+		return nil
+	}
+
+	if _, err := ioutil.ReadFile(head); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Messages of git failing to read a repository because it is corrupt.
+var (
+	notRepoMessage = regexp.MustCompile( // nolint: gochecknoglobals
+		`(?i)not a git repository`)
+	corruptMessage = regexp.MustCompile( // nolint: gochecknoglobals
+		`(?i)not a git repository|bad object|corrupt|missing object|` +
+			`invalid (sha1|object|ref)|does not point to a valid object|` +
+			`unable to read|bad (ref|head)|broken`)
+)
+
+// corrupt checks if git failed because the repository is corrupt.
+func corrupt(err error) bool {
+	return err != nil && corruptMessage.MatchString(gitStderr(err))
+}
+
+// gitStderr returns what git wrote to standard error when the error is a git
+// failure.
+func gitStderr(err error) string {
+	var gitErr *errs.GitError
+	if errors.As(err, &gitErr) {
+		return gitErr.Stderr
+	}
+
+	return ""
+}
+
+// ctxErr returns the error of the context when it is done, since git failed
+// because it was killed then.  It returns nil otherwise.
+func ctxErr(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return contextErr(ctx)
+	}
+
+	return nil
+}
+
+// hasEntry returns the entry with the name, or nil when there is none.
+func hasEntry(entries []fs.DirEntry, name string) fs.DirEntry {
+	for _, e := range entries {
+		if e.Name() == name {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// checkState handles the local repository before syncing it.  An empty
+// directory or a clone that did not finish is removed so that it is cloned
+// again, unless it is a dry run.  A directory that is not a repository is never
+// touched.  A broken repository is repaired when repair is set.
+//
+// It returns true when the state decided the action on its own.  Otherwise the
+// reason is only set when a leftover of a clone was removed.
+func checkState(
+	ctx context.Context,
+	r Repo,
+	opts SyncOptions,
+) (Action, string, bool, error) {
+	state, err := inspect(ctx, r)
+	if err != nil {
+		return ActionFetch, "", true, err
+	}
+
+	// Checking changes nothing, so a dry run plans the repairs as well.
+	if state == stateOK && opts.Repair {
+		if err := git.Fsck(ctx, r.Path); err != nil {
+			if err := ctxErr(ctx); err != nil {
+				return ActionRepair, reasonBroken, true, err
+			}
+
+			state = stateBroken
+		}
+	}
+
+	switch state {
+	case stateEmpty, stateIncomplete:
+		reason := reasonEmpty
+		if state == stateIncomplete {
+			reason = reasonIncomplete
+		}
+
+		if opts.DryRun {
+			return ActionClone, reason, true, nil
+		}
+
+		// Both have nothing to lose, and cloning needs the path to be free.
+		return ActionClone, reason, false, os.RemoveAll(r.Path)
+	case stateNotRepo:
+		return ActionClone, reasonNotRepo, true,
+			fmt.Errorf("%w: %s", errs.ErrNotRepo, r.Path)
+	case stateBroken:
+		switch {
+		// A linked worktree cannot be cloned again.
+		case !opts.Repair, r.Kind == KindWorktree:
+			return ActionFetch, reasonBroken, true, errs.ErrBrokenRepo
+		case opts.DryRun:
+			return ActionRepair, reasonBroken, true, nil
+		}
+
+		return ActionRepair, reasonBroken, true, repair(ctx, r)
+	default:
+		return "", "", false, nil
+	}
+}
+
+// cloneAtomic calls clone with a temporary directory next to path, and renames
+// it to path once clone succeeded.  A clone that does not finish, such as when
+// git is killed, never leaves a half-written repository at path.
+func cloneAtomic(path string, clone func(tmp string) error) error {
+	tmp, err := cloneTemp(path, clone)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.RemoveAll(tmp)
+
+		return err
+	}
+
+	return nil
+}
+
+// cloneTemp calls clone with a new temporary directory next to path, and
+// returns the directory once clone succeeded.  The directory is removed when
+// clone fails.
+func cloneTemp(path string, clone func(tmp string) error) (string, error) {
+	parent := filepath.Dir(path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := tempDir(parent, "."+filepath.Base(path)+".clone-")
+	if err != nil {
+		return "", err
+	}
+
+	if err := clone(tmp); err != nil {
+		os.RemoveAll(tmp)
+
+		return "", err
+	}
+
+	return tmp, nil
+}
+
+// tempDir makes a new directory in dir with a name starting with the prefix.
+// Unlike ioutil.TempDir, its permissions follow the umask, as those of the
+// directory made by a plain clone do.
+func tempDir(dir, prefix string) (string, error) {
+	const tries = 100
+
+	var err error
+
+	for i := 0; i < tries; i++ {
+		suffix := strconv.FormatInt(time.Now().UnixNano()+int64(i), 36)
+		name := filepath.Join(dir, prefix+suffix)
+
+		err = os.Mkdir(name, 0777)
+
+		switch {
+		case err == nil:
+			return name, nil
+		case !os.IsExist(err):
+			return "", err
+		}
+	}
+
+	return "", err
+}
+
+// repair clones the repo again, keeping the local branches that can still be
+// read from the broken repository.  Nothing is changed when the branches
+// cannot be listed, since they would be lost.  The broken repository is moved
+// next to it rather than deleted, in case anything else is needed from it.  It
+// is moved back when the new clone cannot take its place.
+func repair(ctx context.Context, r Repo) error {
+	// Git would read the branches of a parent repository otherwise.
+	own, err := ownRepo(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	if !own {
+		return errs.ErrUnreadableBranches
+	}
+
+	branches, err := git.LocalBranches(ctx, r.Path)
+	if err != nil {
+		if err := ctxErr(ctx); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("%w: %s", errs.ErrUnreadableBranches, err)
+	}
+
+	// An error means HEAD is detached.
+	current, _ := git.Branch(ctx, r.Path)
+
+	tmp, err := cloneTemp(r.Path, func(tmp string) error {
+		tr := r
+		tr.Path = tmp
+
+		var err error
+		if r.Kind == KindBare {
+			err = cloneBare(ctx, tr)
+		} else {
+			err = cloneRepo(ctx, tr)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return keepBranches(ctx, tr, r.Path, branches, current)
+	})
+	if err != nil {
+		return err
+	}
+
+	old := r.Path + ".broken-" + time.Now().Format("20060102150405")
+
+	if err := os.Rename(r.Path, old); err != nil {
+		os.RemoveAll(tmp)
+
+		return err
+	}
+
+	if err := os.Rename(tmp, r.Path); err != nil {
+		os.RemoveAll(tmp)
+
+		if rbErr := os.Rename(old, r.Path); rbErr != nil {
+			return fmt.Errorf("%w; the broken repository is left at %s",
+				err, old)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// keepBranches fetches the branches from the broken repository into the new
+// clone, and checks out the branch that was checked out.  Branches that cannot
+// be read are lost, since the commits are broken.
+func keepBranches(
+	ctx context.Context,
+	r Repo,
+	broken string,
+	branches []string,
+	current string,
+) error {
+	abs, err := filepath.Abs(broken)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range branches {
+		ref := "refs/heads/" + b
+		// A branch with broken commits cannot be kept.
+		_ = git.Fetch(ctx, r.Path, "--update-head-ok", abs, "+"+ref+":"+ref)
+	}
+
+	if r.Kind == KindBare {
+		return nil
+	}
+
+	if current != "" && git.RefExists(ctx, r.Path, "refs/heads/"+current) {
+		if err := git.Switch(ctx, r.Path, current); err != nil {
+			return err
+		}
+	}
+
+	// The checked out branch may have been updated by the fetch.
+	return git.Run(ctx, "-C", r.Path, "reset", "--hard", "--quiet")
+}
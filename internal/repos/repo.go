@@ -3,7 +3,37 @@ package repos
 // Repo represents a git repository.
 type Repo struct {
 	// Path is the file path on the local machine to the git repository.
-	Path string
+	Path string `json:"path"`
 	// URL is the location of the remote git repository.
-	URL string
+	URL string `json:"url"`
+	// Options are the settings given for the repository in the configuration.
+	Options Options `json:"options"`
+	// Tags are the names of the configuration section the repository is in.
+	Tags []string `json:"tags,omitempty"`
+	// Remotes are the remotes besides the one for URL, such as "upstream" for
+	// a fork.
+	Remotes []Remote `json:"remotes,omitempty"`
+	// Kind is the kind of repository.  It is empty for a regular repository
+	// with its own working directory.
+	Kind Kind `json:"kind,omitempty"`
 }
+
+// Remote is a named remote repository.
+type Remote struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Kind is a kind of git repository.
+type Kind string
+
+const (
+	// KindBare is a repository without a working directory.
+	KindBare Kind = "bare"
+	// KindWorktree is a working directory linked to another repository with
+	// `git worktree add`.
+	KindWorktree Kind = "worktree"
+	// KindSubmodule is a submodule checked out in another repository.  It is
+	// managed by that repository, so it is never configured.
+	KindSubmodule Kind = "submodule"
+)
@@ -0,0 +1,64 @@
+package repos
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+)
+
+// Retry is how git failures that may not happen again, such as a dropped
+// network connection, are retried.  Other failures, such as a failed login or
+// a missing repository, are never retried.  The zero value never retries.
+type Retry struct {
+	// Attempts is the most times a repository is tried.  A value less than 2
+	// never retries.
+	Attempts int
+
+	// Delay is how long to wait before the first retry.  It doubles before
+	// every retry after that.
+	Delay time.Duration
+
+	// MaxDelay limits how long to wait before a retry, when greater than zero.
+	MaxDelay time.Duration
+}
+
+// do calls fn until it succeeds, fails with an error that is not transient,
+// runs out of attempts or the context is done or stopped.  It returns how
+// many times fn was called and its last error.  A failure once the context is
+// done is returned as the error of the context, since git was killed because
+// of it.
+func (rt Retry) do(ctx context.Context, fn func() error) (int, error) {
+	delay := rt.Delay
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err != nil && ctx.Err() != nil {
+			return attempt, contextErr(ctx)
+		}
+
+		if err == nil || !errs.IsTransient(err) || attempt >= rt.Attempts {
+			return attempt, err
+		}
+
+		if rt.MaxDelay > 0 && delay > rt.MaxDelay {
+			delay = rt.MaxDelay
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return attempt, contextErr(ctx)
+		case <-stopped(ctx):
+			timer.Stop()
+
+			return attempt, err
+		case <-timer.C:
+		}
+
+		delay *= 2
+	}
+}
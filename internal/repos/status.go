@@ -0,0 +1,87 @@
+package repos
+
+import (
+	"context"
+	"os"
+
+	"gitlab.com/kibafox/repos/internal/git"
+)
+
+// RepoStatus describes the state of a local git repository.
+type RepoStatus struct {
+	Repo Repo
+
+	// Cloned is false when the local repository does not exist.  The rest of
+	// the fields are only set when Cloned is true.
+	Cloned bool
+
+	// Dirty is true when there are unstaged changes in the working directory.
+	Dirty bool
+	// Staged is true when there are changes staged for commit.
+	Staged bool
+
+	// Upstream is false when the current branch has no upstream.  Ahead and
+	// Behind are only set when Upstream is true.
+	Upstream bool
+	Ahead    uint
+	Behind   uint
+
+	// Branch is the checked out branch.  It is empty when HEAD is detached.
+	Branch string
+	// Origin is the URL of the remote named by Repo.RemoteName.  It is empty
+	// when there is no such remote.
+	Origin string
+	// Drift are the remotes with another URL than the configured one.
+	Drift []RemoteDrift
+}
+
+// Status inspects the local repository of each repo and returns their statuses
+// in the same order.  At most jobs repositories are inspected at the same time.
+func Status(ctx context.Context, repos []Repo, jobs int) ([]RepoStatus, error) {
+	statuses := make([]RepoStatus, len(repos))
+
+	forEach(ctx, repos, jobs, func(i int, r Repo) {
+		statuses[i] = repoStatus(ctx, r)
+	})
+
+	if err := doneErr(ctx); err != nil {
+		return statuses, err
+	}
+
+	return statuses, nil
+}
+
+func repoStatus(ctx context.Context, r Repo) RepoStatus {
+	status := RepoStatus{Repo: r}
+
+	if _, err := os.Stat(r.Path); err != nil {
+		return status
+	}
+
+	status.Cloned = true
+
+	// A bare repository has no working directory to have changes in.
+	if r.Kind != KindBare {
+		status.Dirty = git.Dirty(ctx, r.Path)
+		status.Staged = git.Staged(ctx, r.Path)
+	}
+
+	if up, err := git.UpStatus(ctx, r.Path); err == nil {
+		status.Upstream = true
+		status.Ahead = up.Ahead
+		status.Behind = up.Behind
+	}
+
+	// Errors only mean a detached HEAD or a missing remote, both of which are
+	// shown as empty.
+	status.Branch, _ = git.Branch(ctx, r.Path)
+	status.Origin, _ = git.RemoteURL(ctx, r.Path, r.RemoteName())
+
+	// Git answers for a parent repository when there is no repository at the
+	// path, whose remotes must not be taken for those of this one.
+	if state, err := inspect(ctx, r); err == nil && state == stateOK {
+		status.Drift = remoteDrifts(ctx, r)
+	}
+
+	return status
+}
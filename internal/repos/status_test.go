@@ -0,0 +1,115 @@
+package repos_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/kibafox/repos/internal/git"
+	. "gitlab.com/kibafox/repos/internal/repos"
+)
+
+var _ = Describe("Status", func() {
+	var (
+		repos []Repo
+		dir   string
+	)
+
+	BeforeEach(func() {
+		repos, dir = syncSetupRepos()
+	})
+
+	AfterEach(func() {
+		cleanRepos(dir)
+	})
+
+	It("reports repositories that have not been cloned", func() {
+		statuses, err := Status(context.Background(), repos, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(statuses).Should(Equal([]RepoStatus{
+			{Repo: repos[0]},
+			{Repo: repos[1]},
+		}))
+	})
+
+	It("reports remotes on other URLs than configured", func() {
+		syncSimple(repos)
+
+		old, err := filepath.Abs(repos[0].URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Moving the first repo to the URL of the second one")
+		moved := append([]Repo{}, repos...)
+		moved[0].URL = repos[1].URL
+
+		statuses, err := Status(context.Background(), moved, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(statuses[0].Drift).Should(Equal([]RemoteDrift{
+			{Name: "origin", URL: old, Want: repos[1].URL},
+		}))
+		Expect(statuses[1].Drift).Should(BeEmpty())
+	})
+
+	It("reports the state of cloned repositories", func() {
+		syncSimple(repos)
+
+		By("Committing a TODO file to the first local repo")
+		makeCommit(repos[0].Path, "TODO", "- write tests\n", "Add TODO")
+
+		By("Committing a CONTRIBUTING.md file to the first remote repo")
+		makeCommit(repos[0].URL, "CONTRIBUTING.md", "TODO\n",
+			"Add CONTRIBUTING.md")
+
+		ctx := context.Background()
+		Expect(git.Fetch(ctx, repos[0].Path)).To(Succeed())
+
+		By("Modifying and staging files in the second local repo")
+		readme := path.Join(repos[1].Path, "README.md")
+		Expect(ioutil.WriteFile(readme, []byte("staged\n"), 0600)).
+			To(Succeed())
+		Expect(git.Run(ctx, "-C", repos[1].Path, "add", "README.md")).
+			To(Succeed())
+		Expect(ioutil.WriteFile(readme, []byte("dirty\n"), 0600)).
+			To(Succeed())
+
+		statuses, err := Status(ctx, repos, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		branch, err := git.Branch(ctx, repos[0].URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		// Cloning from a relative path records the absolute path as origin.
+		origins := make([]string, len(repos))
+		for i, r := range repos {
+			origins[i], err = filepath.Abs(r.URL)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(statuses).Should(Equal([]RepoStatus{
+			{
+				Repo:     repos[0],
+				Cloned:   true,
+				Upstream: true,
+				Ahead:    1,
+				Behind:   1,
+				Branch:   branch,
+				Origin:   origins[0],
+			},
+			{
+				Repo:     repos[1],
+				Cloned:   true,
+				Dirty:    true,
+				Staged:   true,
+				Upstream: true,
+				Branch:   branch,
+				Origin:   origins[1],
+			},
+		}))
+	})
+})
@@ -2,50 +2,164 @@ package repos
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"gitlab.com/kibafox/repos/internal/errs"
 	"gitlab.com/kibafox/repos/internal/git"
 )
 
-// Sync takes a slice of git repositories and will do the equivalent of
-// `git fetch` for each.  If the local repository does not exist, the
-// equivalent `git clone` is performed.
+// Action is what Sync did to bring a repository up to date.
+type Action string
+
+const (
+	// ActionClone means the local repository did not exist and was cloned.
+	ActionClone Action = "clone"
+	// ActionPull means the local repository was fast-forwarded to upstream.
+	ActionPull Action = "pull"
+	// ActionFetch means remote changes were fetched but the working directory
+	// was left alone.
+	ActionFetch Action = "fetch"
+	// ActionSkip means there was nothing to do.  Outside of a dry run, this
+	// only happens when a locked repository is already at its commit, since
+	// otherwise a sync always fetches to find out.
+	ActionSkip Action = "skip"
+)
+
+// SyncOptions changes how Sync processes the repositories.
+type SyncOptions struct {
+	// Jobs is the maximum number of repositories synced at the same time.  A
+	// value less than 1 syncs one repository at a time.
+	Jobs int
+
+	// DryRun plans the action for each repository without changing anything.
+	// Remotes are still contacted to find out if there are changes to fetch.
+	DryRun bool
+
+	// Locked checks out the exact commit of repositories with a commit option,
+	// such as the ones returned by Lock, instead of pulling.  HEAD is left
+	// detached at the commit.
+	Locked bool
+
+	// Defaults are the depth, filter and sparse options used for repositories
+	// that do not set their own.
+	Defaults Options
+
+	// Bundles is a directory of bundles written by CreateBundles.  When set,
+	// repositories are cloned or fetched from their bundle instead of their
+	// remote, and then their remotes are set to the configured URLs.  Locked
+	// is ignored.
+	Bundles string
+
+	// SwitchBranch checks out the configured branch of repositories that have
+	// drifted to another one, when the working directory has no changes.
+	// Otherwise drifted repositories are only fetched and warned about.
+	SwitchBranch bool
+
+	// Repair checks existing repositories with 'git fsck', and clones broken
+	// ones again, keeping the local branches that can still be read.
+	// Otherwise broken repositories fail to sync with errs.ErrBrokenRepo.
+	Repair bool
+
+	// FixRemotes sets remotes of existing repositories with another URL than
+	// the configured one, such as after a repository moved, to the configured
+	// URL before fetching.  Otherwise they are fetched from as they are and
+	// warned about.
+	FixRemotes bool
+
+	// Retry is how repositories that failed to sync in a way that may not
+	// happen again, such as a dropped network connection, are retried.
+	Retry Retry
+}
+
+// Result is the outcome of syncing a single repository.
+type Result struct {
+	Repo Repo
+	// Action is what was done to the repository, or what was being done when
+	// Err occurred.  For a dry run it is what would be done.
+	Action Action
+	// Reason explains why the action was chosen.
+	Reason string
+	// OldHead and NewHead are the commits HEAD pointed to before and after
+	// syncing.  Either is empty when there was no commit to point to.
+	OldHead string
+	NewHead string
+	// Duration is how long syncing the repository took.
+	Duration time.Duration
+	// Warnings are problems that did not stop the repository from syncing,
+	// such as a checkout on another branch than the configured one.
+	Warnings []string
+	// Attempts is how many times syncing was tried, more than one when
+	// transient failures were retried.
+	Attempts int
+	// Err is set when the repository failed to sync.  It is an
+	// errs.RepoError.
+	Err error
+}
+
+// Updated reports if syncing moved HEAD to a different commit.
+func (res Result) Updated() bool {
+	return res.Err == nil && res.OldHead != res.NewHead
+}
+
+// Sync takes a slice of git repositories and brings each up to date:
+//
+//   - `git clone` when the local repository does not exist.
+//   - `git pull --ff-only` when the working directory is clean and the local
+//     branch is only behind its upstream.
+//   - `git fetch` otherwise, leaving local changes alone.
+//
+// A repository checked out on another branch than the configured one is only
+// fetched, with a warning, unless opts.SwitchBranch is set.  Likewise, a remote
+// with another URL than the configured one is only warned about, unless
+// opts.FixRemotes is set.
+//
+// Other remotes of a repository are added when missing and fetched as well.
 //
-// Takes in an error channel which sends errors that occur during syncing.
-// The channel is closed at the end of syncing.
-func Sync(ctx context.Context, repos []Repo, errCh chan error) error {
-	if errCh == nil {
+// A repository failing in a way that may not happen again, such as a dropped
+// network connection, is tried again as set by opts.Retry.
+//
+// A bare repository is cloned with --bare and then only ever fetched into.  A
+// linked worktree cannot be cloned, so it fails to sync when missing.
+//
+// Repositories are synced concurrently by a pool of workers sized by
+// opts.Jobs.
+//
+// Takes in a result channel which is sent the result of every repository as
+// soon as it is synced.  The channel is closed at the end of syncing.
+// errs.ErrOccurred is returned when any repository failed to sync.
+func Sync(
+	ctx context.Context,
+	repos []Repo,
+	opts SyncOptions,
+	resCh chan Result,
+) error {
+	if resCh == nil {
 		return errs.ErrNilChan
 	}
 
-	defer close(errCh)
+	defer close(resCh)
 
-	var errOccurred bool
+	var (
+		mu          sync.Mutex
+		errOccurred bool
+	)
 
-	for _, r := range repos {
-		if ctx.Err() != nil {
-			switch {
-			case errors.Is(ctx.Err(), context.Canceled):
-				return errs.ErrContextCanceled
-			case errors.Is(ctx.Err(), context.DeadlineExceeded):
-				return errs.ErrContextTimeout
-			default:
-				return fmt.Errorf("context error: %w", ctx.Err())
-			}
-		} else if _, err := os.Stat(r.Path); err == nil {
-			if err := git.Pull(ctx, r.Path); err != nil {
-				errOccurred = true
-				errCh <- err
-			}
-		} else {
-			if err := git.Clone(ctx, r.URL, r.Path); err != nil {
-				errOccurred = true
-				errCh <- err
-			}
+	forEach(ctx, repos, opts.Jobs, func(_ int, r Repo) {
+		res := syncRepo(ctx, r, opts)
+		if res.Err != nil {
+			mu.Lock()
+			errOccurred = true
+			mu.Unlock()
 		}
+
+		resCh <- res
+	})
+
+	if err := doneErr(ctx); err != nil {
+		return err
 	}
 
 	if errOccurred {
@@ -54,3 +168,554 @@ func Sync(ctx context.Context, repos []Repo, errCh chan error) error {
 
 	return nil
 }
+
+func syncRepo(ctx context.Context, r Repo, opts SyncOptions) Result {
+	start := time.Now()
+	res := Result{Repo: r}
+
+	r.Options = r.Options.withDefaults(opts.Defaults)
+	res.Repo = r
+
+	locked := opts.Locked && opts.Bundles == "" && r.Options.Commit != ""
+	tracked := !locked && r.Kind != KindBare && r.Options.Branch != ""
+
+	// Git answers for a parent repository when there is no repository at the
+	// path, so nothing is read from it before its state is known.
+	var oldBranch string
+
+	if state, err := inspect(ctx, r); err == nil && state == stateOK {
+		// The head is only missing in an empty repository.
+		res.OldHead, _ = git.Head(ctx, r.Path)
+
+		// An error means HEAD is detached.
+		oldBranch, _ = git.Branch(ctx, r.Path)
+
+		res.Warnings, res.Err = syncRemoteURLs(ctx, r, opts)
+	}
+
+	if res.Err != nil {
+		res.Action = ActionFetch
+	} else {
+		res.Attempts, res.Err = opts.Retry.do(ctx, func() (err error) {
+			res.Action, res.Reason, err = syncAttempt(ctx, r, opts, locked)
+
+			return err
+		})
+	}
+
+	if res.Err != nil {
+		res.Err = &errs.RepoError{
+			Path: r.Path,
+			URL:  r.URL,
+			Op:   string(res.Action),
+			Err:  res.Err,
+		}
+	}
+
+	if own, _ := ownRepo(ctx, r); own {
+		res.NewHead, _ = git.Head(ctx, r.Path)
+	}
+
+	if tracked && res.NewHead != "" {
+		res.Warnings = append(res.Warnings,
+			branchWarnings(ctx, r, res.OldHead, oldBranch)...)
+	}
+
+	res.Duration = time.Since(start)
+
+	return res
+}
+
+// syncAttempt plans or takes the action for the repo once.
+func syncAttempt(
+	ctx context.Context,
+	r Repo,
+	opts SyncOptions,
+	locked bool,
+) (Action, string, error) {
+	action, reason, done, err := checkState(ctx, r, opts)
+	if done || err != nil {
+		return action, reason, err
+	}
+
+	action, next, err := syncDispatch(ctx, r, opts, locked)
+
+	// The reason a leftover of a clone was removed is kept.
+	if reason == "" {
+		reason = next
+	}
+
+	return action, reason, err
+}
+
+// syncDispatch plans or takes the action for the repo once its state was
+// checked.
+func syncDispatch(
+	ctx context.Context,
+	r Repo,
+	opts SyncOptions,
+	locked bool,
+) (Action, string, error) {
+	switch {
+	case opts.Bundles != "" && opts.DryRun:
+		return planBundle(ctx, r, opts.Bundles)
+	case opts.Bundles != "":
+		return bundleAction(ctx, r, opts.Bundles, opts.SwitchBranch)
+	case r.Kind == KindBare && opts.DryRun:
+		return planBare(ctx, r)
+	case r.Kind == KindBare:
+		return bareAction(ctx, r)
+	case locked && opts.DryRun:
+		return planLocked(ctx, r)
+	case locked:
+		return lockedAction(ctx, r)
+	case opts.DryRun:
+		return planAction(ctx, r, opts.SwitchBranch)
+	default:
+		return syncAction(ctx, r, opts.SwitchBranch)
+	}
+}
+
+// Reasons given for the chosen action.
+const (
+	reasonNotCloned = "not cloned"
+	reasonDirty     = "working directory has changes"
+	reasonStaged    = "changes staged for commit"
+	reasonUpstream  = "no upstream branch"
+	reasonAhead     = "local commits not upstream"
+	reasonBehind    = "behind upstream"
+	reasonUpToDate  = "up to date"
+	reasonBare      = "bare repository"
+	reasonRemotes   = "other remotes to update"
+	reasonRefs      = "other refs to fetch"
+)
+
+func syncAction(
+	ctx context.Context,
+	r Repo,
+	switchBranch bool,
+) (Action, string, error) {
+	if _, err := os.Stat(r.Path); err != nil {
+		if r.Kind == KindWorktree {
+			return ActionClone, reasonNotCloned, errs.ErrWorktreeMissing
+		}
+
+		return ActionClone, reasonNotCloned, clone(ctx, r)
+	}
+
+	fetchArgs := append(r.Options.fetchArgs(), r.RemoteName())
+	if err := git.Fetch(ctx, r.Path, fetchArgs...); err != nil {
+		return ActionFetch, "", err
+	}
+
+	if err := syncRemotes(ctx, r); err != nil {
+		return ActionFetch, "", err
+	}
+
+	return updateCheckout(ctx, r, switchBranch)
+}
+
+// updateCheckout brings the checkout up to date with what was fetched, when
+// the working directory has no changes.
+func updateCheckout(
+	ctx context.Context,
+	r Repo,
+	switchBranch bool,
+) (Action, string, error) {
+	if reason := worktreeChanges(ctx, r); reason != "" {
+		return ActionFetch, reason, nil
+	}
+
+	if err := syncSparse(ctx, r); err != nil {
+		return ActionFetch, "", err
+	}
+
+	drift := branchDrift(ctx, r)
+	if drift != "" {
+		if !switchBranch {
+			return ActionFetch, drift, nil
+		}
+
+		if err := git.Switch(ctx, r.Path, r.Options.Branch); err != nil {
+			return ActionCheckout, drift, err
+		}
+	}
+
+	status, err := git.UpStatus(ctx, r.Path)
+
+	switch {
+	case err != nil && drift != "":
+		return ActionCheckout, drift, nil
+	case err != nil:
+		return ActionFetch, reasonUpstream, nil
+	case status.OnlyBehind():
+		return ActionPull, reasonBehind, git.FastForward(ctx, r.Path)
+	case drift != "":
+		return ActionCheckout, drift, nil
+	case status.Ahead > 0:
+		return ActionFetch, reasonAhead, nil
+	default:
+		return ActionFetch, reasonUpToDate, nil
+	}
+}
+
+// branchDrift returns the reason the checkout is not on the configured branch,
+// or an empty string when it is or no branch is configured.
+func branchDrift(ctx context.Context, r Repo) string {
+	if r.Options.Branch == "" || r.Kind == KindBare {
+		return ""
+	}
+
+	branch, err := git.Branch(ctx, r.Path)
+
+	switch {
+	case err != nil:
+		return "HEAD detached from branch " + r.Options.Branch
+	case branch != r.Options.Branch:
+		return "on branch " + branch + " instead of " + r.Options.Branch
+	default:
+		return ""
+	}
+}
+
+// syncRemoteURLs warns about the remotes of the repo with other URLs than the
+// configured ones, and sets them to the configured URLs when opts.FixRemotes
+// is set.  Syncing from bundles always sets them.
+func syncRemoteURLs(
+	ctx context.Context,
+	r Repo,
+	opts SyncOptions,
+) ([]string, error) {
+	if opts.Bundles != "" {
+		return nil, nil
+	}
+
+	var warnings []string
+
+	drifts := remoteDrifts(ctx, r)
+
+	for _, d := range drifts {
+		switch {
+		case !opts.FixRemotes:
+			warnings = append(warnings,
+				d.String()+"; fix it with 'sync --fix-remotes'")
+		case opts.DryRun:
+			warnings = append(warnings, fmt.Sprintf(
+				"remote %s would be changed from %s to %s",
+				d.Name, d.URL, d.Want))
+		default:
+			warnings = append(warnings, fmt.Sprintf(
+				"remote %s changed from %s to %s", d.Name, d.URL, d.Want))
+		}
+	}
+
+	if !opts.FixRemotes || opts.DryRun {
+		return warnings, nil
+	}
+
+	return warnings, fixRemotes(ctx, r, drifts)
+}
+
+// branchWarnings warns when syncing switched the branch of the repo, or left
+// it on another branch than the configured one.
+func branchWarnings(
+	ctx context.Context,
+	r Repo,
+	oldHead, oldBranch string,
+) []string {
+	var warnings []string
+
+	// An error means HEAD is detached.
+	branch, _ := git.Branch(ctx, r.Path)
+
+	if oldHead != "" && branch != oldBranch {
+		warnings = append(warnings, fmt.Sprintf(
+			"switched from %s to branch %s", describeBranch(oldBranch),
+			r.Options.Branch))
+	}
+
+	if branch != r.Options.Branch {
+		warnings = append(warnings, fmt.Sprintf(
+			"%s is checked out instead of branch %s", describeBranch(branch),
+			r.Options.Branch))
+	}
+
+	return warnings
+}
+
+func describeBranch(branch string) string {
+	if branch == "" {
+		return "detached HEAD"
+	}
+
+	return "branch " + branch
+}
+
+// planAction decides what syncAction would do without changing anything.
+func planAction(
+	ctx context.Context,
+	r Repo,
+	switchBranch bool,
+) (Action, string, error) {
+	if _, err := os.Stat(r.Path); err != nil {
+		if r.Kind == KindWorktree {
+			return ActionClone, reasonNotCloned, errs.ErrWorktreeMissing
+		}
+
+		return ActionClone, reasonNotCloned, nil
+	}
+
+	fetchArgs := append(r.Options.fetchArgs(), r.RemoteName())
+
+	refs, err := git.FetchDryRun(ctx, r.Path, fetchArgs...)
+	if err != nil {
+		return ActionFetch, "", err
+	}
+
+	others, err := planRemotes(ctx, r)
+	if err != nil {
+		return ActionFetch, "", err
+	}
+
+	if reason := worktreeChanges(ctx, r); reason != "" {
+		return ActionFetch, reason, nil
+	}
+
+	if drift := branchDrift(ctx, r); drift != "" {
+		if switchBranch {
+			return ActionCheckout, drift, nil
+		}
+
+		return ActionFetch, drift, nil
+	}
+
+	status, err := git.UpStatus(ctx, r.Path)
+
+	// Only an update of the upstream branch is pulled.  New branches and
+	// tags are only fetched.
+	upstream, _ := git.Upstream(ctx, r.Path)
+
+	switch {
+	case err != nil:
+		return ActionFetch, reasonUpstream, nil
+	case status.Ahead > 0:
+		return ActionFetch, reasonAhead, nil
+	case containsString(refs, upstream) || status.Behind > 0:
+		return ActionPull, reasonBehind, nil
+	case len(refs) > 0:
+		return ActionFetch, reasonRefs, nil
+	case others:
+		return ActionFetch, reasonRemotes, nil
+	default:
+		return ActionSkip, reasonUpToDate, nil
+	}
+}
+
+// clone clones the repo with cloneRepo, renaming the clone to its path only
+// once it is done.
+func clone(ctx context.Context, r Repo) error {
+	return cloneAtomic(r.Path, func(tmp string) error {
+		r.Path = tmp
+
+		return cloneRepo(ctx, r)
+	})
+}
+
+// cloneRepo clones the repo with its options, and sets up its sparse checkout
+// and other remotes.
+func cloneRepo(ctx context.Context, r Repo) error {
+	err := git.Clone(ctx, r.URL, r.Path, r.Options.cloneArgs()...)
+	if err != nil {
+		return err
+	}
+
+	if len(r.Options.Sparse) > 0 {
+		err := git.SparseCheckout(ctx, r.Path, r.Options.Sparse...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncRemotes(ctx, r)
+}
+
+// syncSparse updates the sparse checkout when its directories are not the
+// configured ones.  A checkout without sparse directories configured is left
+// as it is.
+func syncSparse(ctx context.Context, r Repo) error {
+	if len(r.Options.Sparse) == 0 {
+		return nil
+	}
+
+	// An error means the checkout is not sparse yet.
+	dirs, _ := git.SparseDirs(ctx, r.Path)
+	if sameStrings(dirs, r.Options.Sparse) {
+		return nil
+	}
+
+	return git.SparseCheckout(ctx, r.Path, r.Options.Sparse...)
+}
+
+// syncRemotes adds the other remotes of the repo that are missing, and then
+// fetches each of them.  Remotes that already exist are left as they are.
+func syncRemotes(ctx context.Context, r Repo) error {
+	for _, remote := range r.Remotes {
+		if _, err := git.RemoteURL(ctx, r.Path, remote.Name); err != nil {
+			err := git.AddRemote(ctx, r.Path, remote.Name, remote.URL)
+			if err != nil {
+				return err
+			}
+		}
+
+		fetchArgs := append(r.Options.fetchArgs(), remote.Name)
+		if err := git.Fetch(ctx, r.Path, fetchArgs...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planRemotes checks if syncRemotes would add any remote or fetch changes from
+// one, without changing anything.
+func planRemotes(ctx context.Context, r Repo) (bool, error) {
+	for _, remote := range r.Remotes {
+		if _, err := git.RemoteURL(ctx, r.Path, remote.Name); err != nil {
+			return true, nil
+		}
+
+		fetchArgs := append(r.Options.fetchArgs(), remote.Name)
+
+		refs, err := git.FetchDryRun(ctx, r.Path, fetchArgs...)
+		if err != nil || len(refs) > 0 {
+			return len(refs) > 0, err
+		}
+	}
+
+	return false, nil
+}
+
+// bareRefspec updates the branches of a bare repository, which has no remote
+// tracking branches of its own.
+const bareRefspec = "+refs/heads/*:refs/heads/*"
+
+// bareAction clones a bare repository when missing, and otherwise fetches the
+// remote branches into it.
+func bareAction(ctx context.Context, r Repo) (Action, string, error) {
+	if _, err := os.Stat(r.Path); err != nil {
+		err := cloneAtomic(r.Path, func(tmp string) error {
+			r.Path = tmp
+
+			return cloneBare(ctx, r)
+		})
+
+		return ActionClone, reasonNotCloned, err
+	}
+
+	fetchArgs := append(r.Options.fetchArgs(), r.RemoteName(), bareRefspec)
+	if err := git.Fetch(ctx, r.Path, fetchArgs...); err != nil {
+		return ActionFetch, "", err
+	}
+
+	return ActionFetch, reasonBare, syncRemotes(ctx, r)
+}
+
+// cloneBare clones the repo with --bare and adds its other remotes.
+func cloneBare(ctx context.Context, r Repo) error {
+	// There is no working directory to make sparse.
+	opts := r.Options
+	opts.Sparse = nil
+
+	args := append([]string{"--bare"}, opts.cloneArgs()...)
+
+	if err := git.Clone(ctx, r.URL, r.Path, args...); err != nil {
+		return err
+	}
+
+	return syncRemotes(ctx, r)
+}
+
+// planBare decides what bareAction would do without changing anything.
+func planBare(ctx context.Context, r Repo) (Action, string, error) {
+	if _, err := os.Stat(r.Path); err != nil {
+		return ActionClone, reasonNotCloned, nil
+	}
+
+	fetchArgs := append(r.Options.fetchArgs(), r.RemoteName(), bareRefspec)
+
+	refs, err := git.FetchDryRun(ctx, r.Path, fetchArgs...)
+	changed := len(refs) > 0
+
+	if err == nil && !changed {
+		changed, err = planRemotes(ctx, r)
+	}
+
+	switch {
+	case err != nil:
+		return ActionFetch, "", err
+	case changed:
+		return ActionFetch, reasonBare, nil
+	default:
+		return ActionSkip, reasonUpToDate, nil
+	}
+}
+
+// worktreeChanges returns the reason the working directory cannot be updated,
+// or an empty string when it is clean.
+func worktreeChanges(ctx context.Context, r Repo) string {
+	switch {
+	case git.Dirty(ctx, r.Path):
+		return reasonDirty
+	case git.Staged(ctx, r.Path):
+		return reasonStaged
+	default:
+		return ""
+	}
+}
+
+// SyncSummary counts the results of a sync.
+type SyncSummary struct {
+	// Cloned is the number of repositories that were cloned, or cloned again
+	// to repair them.
+	Cloned int
+	// Updated is the number of existing repositories where HEAD moved.
+	Updated int
+	// Unchanged is the number of existing repositories where HEAD stayed.
+	Unchanged int
+	// Failed is the number of repositories that failed to sync.
+	Failed int
+	// Failures counts the failed repositories by the errs.KindName of their
+	// error, such as "auth" or "network".
+	Failures map[string]int
+}
+
+// Add counts the result in the summary.
+func (s *SyncSummary) Add(res Result) {
+	switch {
+	case res.Err != nil:
+		s.Failed++
+		s.Failures = addFailure(s.Failures, res.Err)
+	case res.Action == ActionClone, res.Action == ActionRepair:
+		s.Cloned++
+	case res.Updated():
+		s.Updated++
+	default:
+		s.Unchanged++
+	}
+}
+
+// Total is the number of results counted in the summary.
+func (s SyncSummary) Total() int {
+	return s.Cloned + s.Updated + s.Unchanged + s.Failed
+}
+
+// addFailure counts the error by its kind, making the map when needed.
+func addFailure(failures map[string]int, err error) map[string]int {
+	if failures == nil {
+		failures = make(map[string]int)
+	}
+
+	failures[errs.KindName(err)]++
+
+	return failures
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -42,6 +44,169 @@ var _ = Describe("Sync", func() {
 		}
 	})
 
+	It("clones remote repositories concurrently", func() {
+		results := syncSimpleOpts(repos, SyncOptions{Jobs: len(repos)})
+
+		var summary SyncSummary
+		for _, res := range results {
+			Expect(res.Action).Should(Equal(ActionClone))
+			Expect(res.OldHead).Should(BeEmpty())
+			Expect(res.NewHead).Should(Equal(repoHeadHash(res.Repo.URL)))
+
+			summary.Add(res)
+		}
+
+		Expect(summary).Should(Equal(SyncSummary{Cloned: 2}))
+
+		for _, r := range repos {
+			Expect(r.Path).Should(BeADirectory())
+			Expect(path.Join(r.Path, "README.md")).Should(BeARegularFile())
+		}
+	})
+
+	It("adds and fetches other remotes", func() {
+		ctx := context.Background()
+
+		// A relative URL would be taken from inside the local repository.
+		upstream, err := filepath.Abs(repos[1].URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		branch, err := git.Branch(ctx, upstream)
+		Expect(err).ToNot(HaveOccurred())
+
+		repos[0].Remotes = []Remote{{Name: "upstream", URL: upstream}}
+
+		By("Cloning with the other remote")
+		syncSimple(repos[:1])
+
+		Expect(git.RemoteURL(ctx, repos[0].Path, "upstream")).
+			Should(Equal(upstream))
+		Expect(git.Out(ctx, "-C", repos[0].Path, "rev-parse",
+			"upstream/"+branch)).Should(Equal(repoHeadHash(upstream)))
+
+		By("Adding the remote to an existing clone")
+		Expect(git.Run(ctx, "-C", repos[0].Path,
+			"remote", "remove", "upstream")).To(Succeed())
+		makeCommit(upstream, "README.md", "# Updated", "Update README")
+
+		results := syncSimpleOpts(repos[:1], SyncOptions{DryRun: true})
+		Expect(results[0].Action).Should(Equal(ActionFetch))
+
+		syncSimple(repos[:1])
+
+		Expect(git.Out(ctx, "-C", repos[0].Path, "rev-parse",
+			"upstream/"+branch)).Should(Equal(repoHeadHash(upstream)))
+	})
+
+	It("warns about and switches a drifted branch", func() {
+		ctx := context.Background()
+
+		Expect(git.Run(ctx, "-C", repos[0].URL, "branch", "release")).
+			To(Succeed())
+
+		repos[0].Options.Branch = "release"
+
+		syncSimple(repos[:1])
+
+		Expect(git.Run(ctx, "-C", repos[0].Path,
+			"checkout", "--quiet", "-b", "feature")).To(Succeed())
+
+		By("Only fetching without the switch option")
+		results := syncSimpleOpts(repos[:1], SyncOptions{})
+		Expect(results[0].Action).Should(Equal(ActionFetch))
+		Expect(results[0].Reason).Should(ContainSubstring("feature"))
+		Expect(results[0].Warnings).Should(HaveLen(1))
+		Expect(git.Branch(ctx, repos[0].Path)).Should(Equal("feature"))
+
+		By("Checking out the configured branch with the switch option")
+		results = syncSimpleOpts(repos[:1], SyncOptions{SwitchBranch: true})
+		Expect(results[0].Action).Should(Equal(ActionCheckout))
+		Expect(results[0].Warnings).Should(ConsistOf(
+			"switched from branch feature to branch release"))
+		Expect(git.Branch(ctx, repos[0].Path)).Should(Equal("release"))
+	})
+
+	It("keeps a sparse checkout of the configured directories", func() {
+		ctx := context.Background()
+
+		for _, d := range []string{"docs", "src", "tools"} {
+			Expect(os.Mkdir(path.Join(repos[0].URL, d), 0755)).To(Succeed())
+			makeCommit(repos[0].URL, path.Join(d, "README.md"), d, "Add "+d)
+		}
+
+		repos[0].Options.Sparse = []string{"docs"}
+
+		By("Cloning only the sparse directories")
+		syncSimple(repos[:1])
+		Expect(path.Join(repos[0].Path, "README.md")).Should(BeARegularFile())
+		Expect(path.Join(repos[0].Path, "docs")).Should(BeADirectory())
+		Expect(path.Join(repos[0].Path, "src")).ShouldNot(BeADirectory())
+
+		By("Changing the directories on a later sync")
+		syncSimpleOpts(repos[:1], SyncOptions{
+			Defaults: Options{Sparse: []string{"src", "tools"}},
+		})
+		Expect(path.Join(repos[0].Path, "src")).ShouldNot(BeADirectory())
+
+		repos[0].Options.Sparse = []string{"src", "tools"}
+		syncSimple(repos[:1])
+		Expect(path.Join(repos[0].Path, "docs")).ShouldNot(BeADirectory())
+		Expect(path.Join(repos[0].Path, "src")).Should(BeADirectory())
+		Expect(git.SparseDirs(ctx, repos[0].Path)).Should(
+			Equal([]string{"src", "tools"}))
+	})
+
+	It("clones and fetches bare repositories", func() {
+		repos[0].Kind = KindBare
+
+		results := syncSimpleOpts(repos[:1], SyncOptions{})
+		Expect(results[0].Action).Should(Equal(ActionClone))
+		Expect(path.Join(repos[0].Path, "HEAD")).Should(BeARegularFile())
+
+		makeCommit(repos[0].URL, "README.md", "# Updated", "Update README")
+
+		results = syncSimpleOpts(repos[:1], SyncOptions{})
+		Expect(results[0].Action).Should(Equal(ActionFetch))
+		Expect(results[0].NewHead).Should(Equal(repoHeadHash(repos[0].URL)))
+	})
+
+	It("fails to sync a missing linked worktree", func() {
+		repos[0].Kind = KindWorktree
+
+		syncErr(repos[:1], errs.ErrWorktreeMissing)
+		Expect(repos[0].Path).ShouldNot(BeADirectory())
+	})
+
+	It("clones with the branch and remote name from the options", func() {
+		ctx := context.Background()
+
+		By("Creating a release branch in the first remote repo")
+		Expect(git.Run(ctx, "-C", repos[0].URL, "branch", "release")).
+			To(Succeed())
+
+		repos[0].Options = Options{Branch: "release", Remote: "upstream"}
+
+		syncSimple(repos[:1])
+
+		branch, err := git.Branch(ctx, repos[0].Path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(branch).Should(Equal("release"))
+
+		_, err = git.RemoteURL(ctx, repos[0].Path, "upstream")
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Committing to the release branch of the remote repo")
+		Expect(git.Run(ctx, "-C", repos[0].URL, "checkout", "-q", "release")).
+			To(Succeed())
+		makeCommit(repos[0].URL, "CHANGELOG.md", "# Changelog\n",
+			"Add CHANGELOG.md")
+
+		Expect(syncActions(repos[:1])).Should(HaveKeyWithValue(
+			repos[0].Path, ActionPull))
+		Expect(repoHeadHash(repos[0].Path)).Should(
+			Equal(repoHeadHash(repos[0].URL)))
+	})
+
 	It("pulls remote repositories after initial clone", func() {
 		syncSimple(repos)
 
@@ -57,7 +222,19 @@ var _ = Describe("Sync", func() {
 			expectedHeads[r.Path] = repoHeadHash(r.URL)
 		}
 
-		syncSimple(repos)
+		oldHeads := localHeadHashes(repos)
+
+		var summary SyncSummary
+		for _, res := range syncSimpleOpts(repos, SyncOptions{}) {
+			Expect(res.Action).Should(Equal(ActionPull))
+			Expect(res.OldHead).Should(Equal(oldHeads[res.Repo.Path]))
+			Expect(res.NewHead).Should(Equal(expectedHeads[res.Repo.Path]))
+			Expect(res.Updated()).Should(BeTrue())
+
+			summary.Add(res)
+		}
+
+		Expect(summary).Should(Equal(SyncSummary{Updated: 2}))
 
 		for _, r := range repos {
 			Expect(path.Join(r.Path, "CONTRIBUTING.md")).
@@ -67,11 +244,412 @@ var _ = Describe("Sync", func() {
 		Expect(expectedHeads).Should(Equal(localHeadHashes(repos)))
 	})
 
+	It("plans the actions without changing anything on a dry run", func() {
+		dryRun := SyncOptions{DryRun: true}
+
+		syncSimple(repos[1:])
+
+		By("Planning with one repo missing and the other up to date")
+		for _, res := range syncSimpleOpts(repos, dryRun) {
+			if res.Repo.Path == repos[0].Path {
+				Expect(res.Action).Should(Equal(ActionClone))
+			} else {
+				Expect(res.Action).Should(Equal(ActionSkip))
+			}
+		}
+
+		Expect(repos[0].Path).ShouldNot(BeADirectory())
+
+		By("Committing a CONTRIBUTING.md file to the second remote repo")
+		makeCommit(repos[1].URL, "CONTRIBUTING.md", "TODO\n",
+			"Add CONTRIBUTING.md")
+
+		before := repoHeadHash(repos[1].Path)
+		results := syncSimpleOpts(repos[1:], dryRun)
+		Expect(results[0].Action).Should(Equal(ActionPull))
+		Expect(results[0].Reason).ShouldNot(BeEmpty())
+		Expect(repoHeadHash(repos[1].Path)).Should(Equal(before))
+
+		By("Modifying the README.md in the second local repo")
+		readme := path.Join(repos[1].Path, "README.md")
+		Expect(ioutil.WriteFile(readme, []byte("changed\n"), 0600)).
+			To(Succeed())
+
+		results = syncSimpleOpts(repos[1:], dryRun)
+		Expect(results[0].Action).Should(Equal(ActionFetch))
+		Expect(repoHeadHash(repos[1].Path)).Should(Equal(before))
+	})
+
+	It("plans a fetch when only other branches or tags changed", func() {
+		ctx := context.Background()
+		remote := repos[0].URL
+
+		syncSimple(repos[:1])
+
+		By("Adding a branch with a new commit and a tag to the remote")
+		Expect(git.Run(ctx, "-C", remote, "tag", "v1")).To(Succeed())
+		Expect(git.Run(ctx, "-C", remote, "checkout", "--quiet", "-b", "next")).
+			To(Succeed())
+		makeCommit(remote, "NEXT.md", "next\n", "Add NEXT.md")
+
+		results := syncSimpleOpts(repos[:1], SyncOptions{DryRun: true})
+		Expect(results[0].Action).Should(Equal(ActionFetch))
+
+		results = syncSimpleOpts(repos[:1], SyncOptions{})
+		Expect(results[0].Action).Should(Equal(ActionFetch))
+		Expect(results[0].Updated()).Should(BeFalse())
+	})
+
+	It("reports an error when the remote does not exist", func() {
+		repos[0].URL = path.Join(dir, "remote", "missing")
+
+		syncErr(repos[:1], errs.ErrGit)
+
+		Expect(repos[0].Path).ShouldNot(BeADirectory())
+	})
+
+	It("classifies failures and wraps them with the repository", func() {
+		repos[0].URL = "http://127.0.0.1:1/refused.git"
+
+		err := syncResults(repos[:1], SyncOptions{})[0].Err
+		Expect(errors.Is(err, errs.ErrNetwork)).Should(BeTrue())
+		Expect(errs.Kind(err)).Should(Equal(errs.ErrNetwork))
+
+		var repoErr *errs.RepoError
+		Expect(errors.As(err, &repoErr)).Should(BeTrue())
+		Expect(repoErr.Op).Should(Equal(string(ActionClone)))
+		Expect(repoErr.Path).Should(Equal(repos[0].Path))
+		Expect(repoErr.URL).Should(Equal(repos[0].URL))
+
+		var gitErr *errs.GitError
+		Expect(errors.As(err, &gitErr)).Should(BeTrue())
+		Expect(gitErr.ExitCode).Should(Equal(128))
+
+		By("Recognizing a missing remote repository")
+		repos[0].URL = path.Join(dir, "remote", "missing")
+
+		err = syncResults(repos[:1], SyncOptions{})[0].Err
+		Expect(errors.Is(err, errs.ErrNotFound)).Should(BeTrue())
+		Expect(errs.KindName(err)).Should(Equal("not_found"))
+	})
+
+	It("times out git commands and whole syncs", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer listener.Close()
+
+		// Accept connections but never answer, like a hung server.
+		go func() {
+			var conns []net.Conn
+
+			defer func() {
+				for _, conn := range conns {
+					conn.Close()
+				}
+			}()
+
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+
+				conns = append(conns, conn)
+			}
+		}()
+
+		repos[0].URL = "http://" + listener.Addr().String() + "/hung.git"
+
+		By("Killing a git command that runs for too long")
+		ctx := git.WithTimeout(context.Background(), 200*time.Millisecond)
+
+		res := syncResultsCtx(ctx, repos[:1], SyncOptions{})
+		Expect(errors.Is(res[0].Err, errs.ErrGitTimeout)).Should(BeTrue())
+		Expect(errs.KindName(res[0].Err)).Should(Equal("timeout"))
+
+		By("Stopping a whole sync that runs for too long")
+		ctx, cancel := context.WithTimeout(context.Background(),
+			200*time.Millisecond)
+		defer cancel()
+
+		res = syncResultsCtx(ctx, repos[:1], SyncOptions{})
+		Expect(errors.Is(res[0].Err, errs.ErrContextTimeout)).Should(BeTrue())
+		Expect(errs.KindName(res[0].Err)).Should(Equal("timeout"))
+	})
+
+	It("warns about remotes on other URLs unless fixing them", func() {
+		ctx := context.Background()
+
+		syncSimple(repos)
+
+		old, err := git.Origin(ctx, repos[0].Path)
+		Expect(err).ToNot(HaveOccurred())
+
+		moved := repos[0]
+		moved.URL = repos[1].URL
+
+		results := syncSimpleOpts([]Repo{moved}, SyncOptions{})
+		Expect(results[0].Warnings).Should(ConsistOf(
+			ContainSubstring("sync --fix-remotes")))
+		Expect(git.Origin(ctx, moved.Path)).Should(Equal(old))
+
+		By("Planning to fix the remote without changing it")
+		results = syncSimpleOpts([]Repo{moved},
+			SyncOptions{FixRemotes: true, DryRun: true})
+		Expect(results[0].Warnings).Should(ConsistOf(
+			ContainSubstring("would be changed")))
+		Expect(git.Origin(ctx, moved.Path)).Should(Equal(old))
+
+		By("Fixing the remote")
+		results = syncSimpleOpts([]Repo{moved}, SyncOptions{FixRemotes: true})
+		Expect(results[0].Warnings).Should(ConsistOf(
+			ContainSubstring("changed from " + old)))
+		Expect(git.Origin(ctx, moved.Path)).Should(Equal(
+			filepath.Join(filepath.Dir(old), "kira")))
+
+		results = syncSimpleOpts([]Repo{moved}, SyncOptions{})
+		Expect(results[0].Warnings).Should(BeEmpty())
+	})
+
+	It("leaves the remotes of a parent repository alone", func() {
+		ctx := context.Background()
+
+		syncSimple(repos[:1])
+
+		old, err := git.Origin(ctx, repos[0].Path)
+		Expect(err).ToNot(HaveOccurred())
+
+		inner := repos[1]
+		inner.Path = filepath.Join(repos[0].Path, "inner")
+		Expect(os.Mkdir(inner.Path, 0755)).To(Succeed())
+
+		results := syncSimpleOpts([]Repo{inner},
+			SyncOptions{FixRemotes: true, DryRun: true})
+		Expect(results[0].Warnings).Should(BeEmpty())
+		Expect(results[0].OldHead).Should(BeEmpty())
+		Expect(results[0].NewHead).Should(BeEmpty())
+
+		results = syncSimpleOpts([]Repo{inner}, SyncOptions{FixRemotes: true})
+		Expect(results[0].Err).ToNot(HaveOccurred())
+		Expect(results[0].Warnings).Should(BeEmpty())
+		Expect(results[0].OldHead).Should(BeEmpty())
+		Expect(git.Origin(ctx, repos[0].Path)).Should(Equal(old))
+
+		url, err := filepath.Abs(inner.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(git.Origin(ctx, inner.Path)).Should(Equal(url))
+	})
+
+	It("clones into empty directories and leftovers of clones", func() {
+		ctx := context.Background()
+
+		Expect(os.MkdirAll(repos[0].Path, 0755)).To(Succeed())
+		Expect(git.Run(ctx, "init", "--quiet", repos[1].Path)).To(Succeed())
+
+		for _, res := range syncSimpleOpts(repos, SyncOptions{}) {
+			Expect(res.Action).Should(Equal(ActionClone))
+			Expect(res.Reason).Should(BeElementOf(
+				"empty directory", "clone did not finish"))
+			Expect(repoHeadHash(res.Repo.Path)).
+				Should(Equal(repoHeadHash(res.Repo.URL)))
+		}
+
+		By("Leaving no temporary directories behind")
+		for _, r := range repos {
+			tmps, err := filepath.Glob(
+				filepath.Join(filepath.Dir(r.Path), ".*.clone-*"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tmps).Should(BeEmpty())
+		}
+
+		By("Making the clones with the permissions of the umask")
+		plain := path.Join(dir, "plain")
+		Expect(os.Mkdir(plain, 0777)).To(Succeed())
+
+		want, err := os.Stat(plain)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, r := range repos {
+			info, err := os.Stat(r.Path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).Should(Equal(want.Mode().Perm()))
+		}
+	})
+
+	It("does not clone into directories with other files", func() {
+		Expect(os.MkdirAll(repos[0].Path, 0755)).To(Succeed())
+
+		notes := path.Join(repos[0].Path, "notes.txt")
+		Expect(ioutil.WriteFile(notes, []byte("mine\n"), 0600)).To(Succeed())
+
+		syncErr(repos[:1], errs.ErrNotRepo)
+
+		Expect(notes).Should(BeARegularFile())
+		Expect(path.Join(repos[0].Path, ".git")).ShouldNot(BeADirectory())
+	})
+
+	It("repairs broken repositories keeping their local branches", func() {
+		ctx := context.Background()
+		local := repos[0].Path
+
+		syncSimple(repos[:1])
+
+		Expect(git.Run(ctx, "-C", local, "checkout", "--quiet", "-b", "keep")).
+			To(Succeed())
+		makeCommit(local, "KEEP.md", "keep", "Add KEEP.md")
+		Expect(git.Run(ctx, "-C", local, "checkout", "--quiet", "-b", "lost")).
+			To(Succeed())
+		makeCommit(local, "LOST.md", "lost", "Add LOST.md")
+		Expect(git.Run(ctx, "-C", local, "checkout", "--quiet", "keep")).
+			To(Succeed())
+
+		By("Deleting an object only the lost branch needs")
+		blob, err := git.Out(ctx, "-C", local, "rev-parse", "lost:LOST.md")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Remove(path.Join(local, ".git", "objects",
+			blob[:2], blob[2:]))).To(Succeed())
+
+		By("Leaving a directory behind where an earlier repair cloned to")
+		Expect(os.MkdirAll(path.Join(local+".repair", "leftover"), 0755)).
+			To(Succeed())
+
+		By("Planning the repair without changing anything")
+		results := syncSimpleOpts(repos[:1],
+			SyncOptions{Repair: true, DryRun: true})
+		Expect(results[0].Action).Should(Equal(ActionRepair))
+		Expect(git.RefExists(ctx, local, "refs/heads/lost")).Should(BeTrue())
+
+		broken, err := filepath.Glob(local + ".broken-*")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(broken).Should(BeEmpty())
+
+		By("Checking the repository with git fsck")
+		results = syncSimpleOpts(repos[:1], SyncOptions{Repair: true})
+		Expect(results[0].Action).Should(Equal(ActionRepair))
+
+		Expect(git.RefExists(ctx, local, "refs/heads/keep")).Should(BeTrue())
+		Expect(git.RefExists(ctx, local, "refs/heads/lost")).Should(BeFalse())
+		Expect(git.Branch(ctx, local)).Should(Equal("keep"))
+		Expect(git.Fsck(ctx, local)).To(Succeed())
+
+		broken, err = filepath.Glob(local + ".broken-*")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(broken).Should(HaveLen(1))
+
+		By("Failing on a broken repository without repairing it")
+		Expect(os.Remove(path.Join(local, ".git", "HEAD"))).To(Succeed())
+		syncErr(repos[:1], errs.ErrBrokenRepo)
+
+		By("Leaving it as it is when its branches cannot be read")
+		err = syncResults(repos[:1], SyncOptions{Repair: true})[0].Err
+		Expect(errors.Is(err, errs.ErrUnreadableBranches)).Should(BeTrue())
+		Expect(path.Join(local, ".git")).Should(BeADirectory())
+
+		broken, err = filepath.Glob(local + ".broken-*")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(broken).Should(HaveLen(1))
+	})
+
+	It("does not repair repositories git refuses to read", func() {
+		if os.Geteuid() != 0 {
+			Skip("changing the owner of a repository needs root")
+		}
+
+		local := repos[0].Path
+
+		syncSimple(repos[:1])
+
+		By("Giving the repository to another user")
+		chown := func(p string, _ os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			return os.Lchown(p, 65534, 65534)
+		}
+		Expect(filepath.Walk(local, chown)).To(Succeed())
+
+		err := syncResults(repos[:1], SyncOptions{Repair: true})[0].Err
+		Expect(errors.Is(err, errs.ErrGit)).Should(BeTrue())
+		Expect(errors.Is(err, errs.ErrBrokenRepo)).Should(BeFalse())
+
+		broken, err := filepath.Glob(local + ".broken-*")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(broken).Should(BeEmpty())
+	})
+
+	It("stops starting repositories once stopped", func() {
+		stop := make(chan struct{})
+		close(stop)
+
+		var (
+			resCh = make(chan Result, len(repos))
+			ctx   = WithStop(context.Background(), stop)
+		)
+
+		err := Sync(ctx, repos, SyncOptions{}, resCh)
+		Expect(errors.Is(err, errs.ErrInterrupted)).Should(BeTrue())
+		Expect(resCh).Should(BeClosed())
+
+		for _, r := range repos {
+			Expect(r.Path).ShouldNot(BeADirectory())
+		}
+	})
+
+	It("retries transient failures but not permanent ones", func() {
+		retry := SyncOptions{Retry: Retry{
+			Attempts: 3,
+			Delay:    time.Millisecond,
+		}}
+
+		By("Retrying a remote that refuses connections")
+		repos[0].URL = "http://127.0.0.1:1/refused.git"
+
+		res := syncResults(repos[:1], retry)
+		Expect(errs.IsTransient(res[0].Err)).Should(BeTrue())
+		Expect(res[0].Attempts).Should(Equal(3))
+
+		By("Failing fast on a remote that does not exist")
+		repos[0].URL = path.Join(dir, "remote", "missing")
+
+		res = syncResults(repos[:1], retry)
+		Expect(errors.Is(res[0].Err, errs.ErrGit)).Should(BeTrue())
+		Expect(errs.IsTransient(res[0].Err)).Should(BeFalse())
+		Expect(res[0].Attempts).Should(Equal(1))
+
+		By("Counting failures by kind")
+		var summary SyncSummary
+		for _, url := range []string{
+			"http://127.0.0.1:1/refused.git",
+			path.Join(dir, "remote", "missing"),
+		} {
+			repos[0].URL = url
+			summary.Add(syncResults(repos[:1], SyncOptions{})[0])
+		}
+
+		Expect(summary.Failures).Should(Equal(map[string]int{
+			"network":   1,
+			"not_found": 1,
+		}))
+
+		By("Trying once without a retry policy")
+		syncSimpleOpts(repos[1:], SyncOptions{})
+		Expect(syncSimpleOpts(repos[1:], retry)[0].Attempts).Should(Equal(1))
+	})
+
 	It("does nothing when there are no updates", func() {
 		syncSimple(repos)
 		before := localHeadHashes(repos)
 
-		syncSimple(repos)
+		var summary SyncSummary
+		for _, res := range syncSimpleOpts(repos, SyncOptions{}) {
+			Expect(res.Updated()).Should(BeFalse())
+			summary.Add(res)
+		}
+
+		Expect(summary).Should(Equal(SyncSummary{Unchanged: 2}))
+
 		after := localHeadHashes(repos)
 
 		Expect(before).Should(Equal(after))
@@ -103,7 +681,8 @@ var _ = Describe("Sync", func() {
 			localHeads[r.Path] = repoHeadHash(r.Path)
 		}
 
-		syncErr(repos, errs.ErrGit)
+		Expect(syncActions(repos)).Should(HaveKeyWithValue(
+			repos[0].Path, ActionFetch))
 		after := localHeadHashes(repos)
 
 		Expect(remoteHeads).ShouldNot(Equal(localHeads))
@@ -139,7 +718,8 @@ var _ = Describe("Sync", func() {
 			Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
 		}
 
-		syncErr(repos, errs.ErrGit)
+		Expect(syncActions(repos)).Should(HaveKeyWithValue(
+			repos[0].Path, ActionFetch))
 
 		By("Verifying we only fetched since there were changes in the worktree")
 		after := localHeadHashes(repos)
@@ -179,9 +759,10 @@ var _ = Describe("Sync", func() {
 			Expect(git.Run(ctx, "-C", r.Path, "add", "README.md")).To(Succeed())
 		}
 
-		syncErr(repos, errs.ErrGit)
+		Expect(syncActions(repos)).Should(HaveKeyWithValue(
+			repos[0].Path, ActionFetch))
 
-		By("Verifying we still pulled since there were no conflicts")
+		By("Verifying we only fetched since there were staged changes")
 		after := localHeadHashes(repos)
 		Expect(remoteHeads).ShouldNot(Equal(after))
 		Expect(before).Should(Equal(after))
@@ -195,48 +776,119 @@ var _ = Describe("Sync", func() {
 
 // syncSimple will do a simple sync, expecting it to complete successfully.
 func syncSimple(repos []Repo) {
+	syncSimpleOpts(repos, SyncOptions{})
+}
+
+// syncSimpleOpts will sync with the given options, expecting it to complete
+// successfully.  The results are returned in the order they were received.
+func syncSimpleOpts(repos []Repo, opts SyncOptions) []Result {
 	var (
 		err         error
-		errs        = make(chan error, 1)
+		results     []Result
+		resCh       = make(chan Result, 1)
+		done        = make(chan struct{})
 		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
 	)
 
 	defer cancel()
 
 	go func() {
-		for err := range errs {
-			log.Println(fmt.Errorf("sync: %w", err))
-		}
-	}()
+		defer close(done)
 
-	go func() {
-		err = Sync(ctx, repos, errs)
+		err = Sync(ctx, repos, opts, resCh)
 	}()
 
-	Consistently(errs).ShouldNot(Receive())
+	for res := range resCh {
+		Expect(res.Err).ShouldNot(HaveOccurred())
+		results = append(results, res)
+	}
+
+	<-done
+
 	Expect(err).ShouldNot(HaveOccurred())
 	Expect(ctx.Err()).ToNot(HaveOccurred())
+	Expect(results).Should(HaveLen(len(repos)))
+
+	return results
 }
 
-// syncErr will expect one type of error to be pushed to the error channel.
+// syncResults will sync with the given options and return every result, even
+// the failed ones.
+func syncResults(repos []Repo, opts SyncOptions) []Result {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	results := syncResultsCtx(ctx, repos, opts)
+	Expect(ctx.Err()).ToNot(HaveOccurred())
+
+	return results
+}
+
+// syncResultsCtx is syncResults with the given context.
+func syncResultsCtx(
+	ctx context.Context,
+	repos []Repo,
+	opts SyncOptions,
+) []Result {
+	var (
+		results []Result
+		resCh   = make(chan Result, 1)
+		done    = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		_ = Sync(ctx, repos, opts, resCh)
+	}()
+
+	for res := range resCh {
+		results = append(results, res)
+	}
+
+	<-done
+
+	Expect(results).Should(HaveLen(len(repos)))
+
+	return results
+}
+
+// syncActions will sync expecting success and returns the action reported for
+// each repository path.
+func syncActions(repos []Repo) map[string]Action {
+	actions := make(map[string]Action)
+
+	for _, res := range syncSimpleOpts(repos, SyncOptions{}) {
+		actions[res.Repo.Path] = res.Action
+	}
+
+	return actions
+}
+
+// syncErr will expect every repository to fail with one type of error.
 func syncErr(repos []Repo, err error) {
 	var (
 		syncErr     error
-		errChan     = make(chan error, 1)
+		resCh       = make(chan Result, 1)
+		done        = make(chan struct{})
 		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
 	)
 
 	defer cancel()
 
 	go func() {
-		syncErr = Sync(ctx, repos, errChan)
+		defer close(done)
+
+		syncErr = Sync(ctx, repos, SyncOptions{}, resCh)
 	}()
 
-	for e := range errChan {
-		log.Println(fmt.Errorf("sync: %w", e))
-		Expect(errors.Unwrap(e)).Should(Equal(err))
+	for res := range resCh {
+		log.Println(fmt.Errorf("sync: %w", res.Err))
+		Expect(errors.Is(res.Err, err)).Should(BeTrue())
 	}
 
+	<-done
+
 	Expect(syncErr).Should(HaveOccurred())
 	Expect(ctx.Err()).ToNot(HaveOccurred())
 }
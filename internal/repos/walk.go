@@ -0,0 +1,396 @@
+package repos
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gitlab.com/kibafox/repos/internal/errs"
+)
+
+// IgnoreFile is the name of the file listing exclude patterns for the
+// directory it is in.  It is read while searching for repositories.
+const IgnoreFile = ".reposignore"
+
+// ImportOptions changes how FromPath searches for repositories.
+type ImportOptions struct {
+	// Exclude holds glob patterns of paths that are not searched.  A pattern
+	// without a slash matches the name of any file or directory.  Otherwise it
+	// matches the whole path when absolute, or the path relative to the one
+	// being searched.
+	Exclude []string
+
+	// MaxDepth is how many directories below the path being searched a
+	// repository can be found in.  A value less than 1 has no limit.
+	MaxDepth int
+
+	// Jobs is the maximum number of directories read, and of remotes looked
+	// up, at the same time.  A value less than 1 does one at a time.
+	Jobs int
+}
+
+// excludes are the exclude patterns that apply below a directory.
+type excludes struct {
+	dir      string
+	patterns []string
+}
+
+func newExcludes(dir string, patterns []string) (excludes, error) {
+	ex := excludes{dir: dir}
+
+	for _, p := range patterns {
+		p = filepath.Clean(strings.TrimSuffix(p, "/"))
+
+		if _, err := filepath.Match(p, ""); err != nil {
+			return ex, fmt.Errorf("%w: %s", errs.ErrExcludePattern, p)
+		}
+
+		ex.patterns = append(ex.patterns, p)
+	}
+
+	return ex, nil
+}
+
+// match checks if the path matches any of the patterns.
+func (ex excludes) match(path string) bool {
+	for _, p := range ex.patterns {
+		var target string
+
+		switch {
+		case !strings.ContainsRune(p, filepath.Separator):
+			target = filepath.Base(path)
+		case filepath.IsAbs(p):
+			target = path
+		default:
+			rel, err := filepath.Rel(ex.dir, path)
+			if err != nil {
+				continue
+			}
+
+			target = rel
+		}
+
+		if ok, _ := filepath.Match(p, target); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readIgnoreFile reads the exclude patterns of the ignore file.  Empty lines
+// and lines starting with a hash (#) are skipped.
+func readIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var (
+		patterns []string
+		scanner  = bufio.NewScanner(f)
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		patterns = append(patterns, line)
+	}
+
+	return patterns, scanner.Err()
+}
+
+// dir is a directory waiting to be searched.
+type dir struct {
+	path  string
+	depth int
+	exs   []excludes
+}
+
+// walker searches directories for repositories with a pool of workers sharing
+// a queue of directories.  Only directory entries are read, so excluded entries
+// are skipped without a stat.  The repositories found are sent on the found
+// channel without a URL.
+type walker struct {
+	ctx      context.Context
+	maxDepth int
+	errCh    chan error
+	found    chan Repo
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	queue       []dir
+	pending     int
+	errOccurred bool
+}
+
+func newWalker(
+	ctx context.Context,
+	maxDepth int,
+	errCh chan error,
+	found chan Repo,
+) *walker {
+	w := &walker{ctx: ctx, maxDepth: maxDepth, errCh: errCh, found: found}
+	w.cond = sync.NewCond(&w.mu)
+
+	return w
+}
+
+func (w *walker) err(err error) {
+	w.mu.Lock()
+	w.errOccurred = true
+	w.mu.Unlock()
+
+	w.errCh <- err
+}
+
+// walk searches the root and everything below it that is not excluded, using
+// at most jobs concurrent workers.  Only context errors stop the walk.
+func (w *walker) walk(root string, jobs int, exs []excludes) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	w.push(dir{path: root, exs: exs})
+
+	var wg sync.WaitGroup
+
+	wg.Add(jobs)
+
+	for n := 0; n < jobs; n++ {
+		go func() {
+			defer wg.Done()
+
+			for {
+				d, ok := w.pop()
+				if !ok {
+					return
+				}
+
+				if w.ctx.Err() == nil {
+					w.visit(d)
+				}
+
+				w.done()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return doneErr(w.ctx)
+}
+
+// push queues a directory to be searched.
+func (w *walker) push(d dir) {
+	w.mu.Lock()
+	w.queue = append(w.queue, d)
+	w.pending++
+	w.mu.Unlock()
+
+	w.cond.Signal()
+}
+
+// pop waits for a queued directory.  It returns false once every directory has
+// been searched.
+func (w *walker) pop() (dir, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.queue) == 0 {
+		if w.pending == 0 {
+			return dir{}, false
+		}
+
+		w.cond.Wait()
+	}
+
+	d := w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+
+	return d, true
+}
+
+// done marks a popped directory as searched.
+func (w *walker) done() {
+	w.mu.Lock()
+	w.pending--
+	last := w.pending == 0
+	w.mu.Unlock()
+
+	if last {
+		w.cond.Broadcast()
+	}
+}
+
+// visit reads the directory, reporting it when it is a repository and queuing
+// the directories in it.
+func (w *walker) visit(d dir) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		w.err(fmt.Errorf("error visiting path: %s: %w", d.path, err))
+
+		return
+	}
+
+	if isBare(entries) {
+		w.found <- Repo{Path: d.path, Kind: KindBare}
+
+		// There are no repositories to find among the git objects.
+		return
+	}
+
+	exs := d.exs
+
+	for _, e := range entries {
+		if e.Name() == IgnoreFile {
+			exs = w.ignoreFile(d.path, exs)
+
+			break
+		}
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(d.path, e.Name())
+
+		if excluded(exs, path) {
+			continue
+		}
+
+		if e.Name() == ".git" {
+			w.gitEntry(d.path, e)
+
+			continue
+		}
+
+		if !e.IsDir() || w.maxDepth > 0 && d.depth >= w.maxDepth {
+			continue
+		}
+
+		w.push(dir{path: path, depth: d.depth + 1, exs: exs})
+	}
+}
+
+// gitEntry reports the repository of a ".git" entry.  A directory is a regular
+// repository, while a file points to the git directory of a linked worktree or
+// a submodule.
+func (w *walker) gitEntry(path string, e fs.DirEntry) {
+	if e.IsDir() {
+		w.found <- Repo{Path: path}
+
+		return
+	}
+
+	if !e.Type().IsRegular() {
+		return
+	}
+
+	gitFile := filepath.Join(path, e.Name())
+
+	gitDir, err := readGitFile(gitFile)
+	if err != nil {
+		w.err(fmt.Errorf("error reading %s: %w", gitFile, err))
+
+		return
+	}
+
+	w.found <- Repo{Path: path, Kind: gitDirKind(gitDir)}
+}
+
+// isBare checks if the directory entries are those of a bare repository.
+func isBare(entries []fs.DirEntry) bool {
+	var head, objects, refs bool
+
+	for _, e := range entries {
+		switch e.Name() {
+		case ".git":
+			return false
+		case "HEAD":
+			head = e.Type().IsRegular()
+		case "objects":
+			objects = e.IsDir()
+		case "refs":
+			refs = e.IsDir()
+		}
+	}
+
+	return head && objects && refs
+}
+
+// readGitFile reads the git directory from a ".git" file.
+func readGitFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", errs.ErrGitFile
+	}
+
+	gitDir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+
+	return filepath.Clean(gitDir), nil
+}
+
+// gitDirKind decides the kind of repository from where its git directory is.
+// Git keeps linked worktrees in "worktrees" and submodules in "modules" under
+// the git directory of the repository they belong to.  Anything else, such as
+// a repository made with --separate-git-dir, is a regular repository.
+func gitDirKind(gitDir string) Kind {
+	parts := strings.Split(filepath.ToSlash(gitDir), "/")
+
+	switch {
+	case len(parts) > 1 && parts[len(parts)-2] == "worktrees":
+		return KindWorktree
+	case strings.Contains(filepath.ToSlash(gitDir), "/modules/"):
+		return KindSubmodule
+	default:
+		return ""
+	}
+}
+
+// ignoreFile adds the patterns of the ignore file in the directory.  The given
+// slice is never changed, so the patterns only apply below the directory.
+func (w *walker) ignoreFile(parent string, exs []excludes) []excludes {
+	path := filepath.Join(parent, IgnoreFile)
+
+	patterns, err := readIgnoreFile(path)
+	if err != nil {
+		w.err(fmt.Errorf("error reading %s: %w", path, err))
+
+		return exs
+	}
+
+	ex, err := newExcludes(parent, patterns)
+	if err != nil {
+		w.err(fmt.Errorf("error reading %s: %w", path, err))
+	}
+
+	return append(exs[:len(exs):len(exs)], ex)
+}
+
+func excluded(exs []excludes, path string) bool {
+	for _, ex := range exs {
+		if ex.match(path) {
+			return true
+		}
+	}
+
+	return false
+}